@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"rindag/model"
+	"rindag/service/db"
+	"rindag/service/git"
+	"rindag/service/problem"
+
+	"github.com/gin-gonic/gin"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// getProblemRev returns the problem and the commit of the "rev" query, which is HEAD by default,
+// if the current user is a viewer of the problem.
+//
+// If it fails, an error response is written.
+func getProblemRev(c *gin.Context) (*problem.Problem, [20]byte, bool) {
+	var rev [20]byte
+
+	_, problem, ok := getProblem(c, model.RoleViewer)
+	if !ok {
+		return nil, rev, false
+	}
+
+	repo, err := problem.Repo()
+	if err != nil {
+		log.WithError(err).Error("failed to get problem repo")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem repo"})
+		return nil, rev, false
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(c.DefaultQuery("rev", "HEAD")))
+	if err != nil {
+		log.WithError(err).Error("failed to resolve revision")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to resolve revision"})
+		return nil, rev, false
+	}
+
+	return problem, *hash, true
+}
+
+// @summary     ProblemTree
+// @description List a directory in the repository of a problem.
+// @tags        problem
+// @produce     json
+// @param       id   path     string true  "Problem ID"
+// @param       rev  query    string false "Commit hash, HEAD by default"
+// @param       path query    string false "Directory path, the root by default"
+// @success     200  {object} any{entries=[]problem.TreeEntry}
+// @failure     400  {object} any{error=string}
+// @failure     403  {object} any{error=string}
+// @failure     404  {object} any{error=string}
+// @failure     500  {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/tree [get]
+func HandleProblemTree(c *gin.Context) {
+	problem, rev, ok := getProblemRev(c)
+	if !ok {
+		return
+	}
+
+	entries, err := problem.Tree(rev, c.Query("path"))
+	if errors.Is(err, object.ErrDirectoryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "directory not found"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to get problem tree")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem tree"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// @summary     ProblemBlob
+// @description Get the content of a file in the repository of a problem.
+// @tags        problem
+// @produce     octet-stream
+// @param       id   path     string true  "Problem ID"
+// @param       rev  query    string false "Commit hash, HEAD by default"
+// @param       path query    string true  "File path"
+// @success     200  {file}   binary
+// @failure     400  {object} any{error=string}
+// @failure     403  {object} any{error=string}
+// @failure     404  {object} any{error=string}
+// @failure     500  {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/blob [get]
+func HandleProblemBlob(c *gin.Context) {
+	path, ok := c.GetQuery("path")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid path"})
+		return
+	}
+
+	problem, rev, ok := getProblemRev(c)
+	if !ok {
+		return
+	}
+
+	file, err := problem.File(rev, path)
+	if errors.Is(err, object.ErrFileNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to get problem file")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem file"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.WithError(err).Error("failed to read problem file")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read problem file"})
+		return
+	}
+
+	c.Data(http.StatusOK, http.DetectContentType(data), data)
+}
+
+// @summary     ProblemLog
+// @description List the commits of a problem from a revision, from the latest one.
+// @description If the path is specified, only the commits changing it are listed.
+// @tags        problem
+// @produce     json
+// @param       id   path     string true  "Problem ID"
+// @param       rev  query    string false "Commit hash, HEAD by default"
+// @param       path query    string false "File or directory path"
+// @param       page query    int    false "Page number, starting from 1"
+// @param       size query    int    false "Page size, at most 100"
+// @success     200  {object} any{commits=[]problem.Commit}
+// @failure     400  {object} any{error=string}
+// @failure     403  {object} any{error=string}
+// @failure     404  {object} any{error=string}
+// @failure     500  {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/log [get]
+func HandleProblemLog(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if err != nil || size < 1 || size > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid size"})
+		return
+	}
+
+	problem, rev, ok := getProblemRev(c)
+	if !ok {
+		return
+	}
+
+	commits, err := problem.Log(rev, c.Query("path"), (page-1)*size, size)
+	if err != nil {
+		log.WithError(err).Error("failed to get problem log")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"commits": commits})
+}
+
+type problemCommitReq struct {
+	Base       string                  `json:"base" binding:"required"`
+	Branch     string                  `json:"branch" default:"main"`
+	Message    string                  `json:"message" binding:"required"`
+	Operations []problem.FileOperation `json:"operations" binding:"required"`
+}
+
+// @summary     ProblemCommit
+// @description Commit file operations to a branch of a problem as the user.
+// @description The commit is rejected if the branch is not at the base revision.
+// @description Like a push, a commit to the main branch is built.
+// @description It requires the editor role, and is rejected by branches requiring a build.
+// @description The commit is declined like a push if it breaks the rules of the branch.
+// @tags        problem
+// @accept      json
+// @produce     json
+// @param       id               path     string           true "Problem ID"
+// @param       problemCommitReq body     problemCommitReq true "Problem commit request"
+// @success     200              {object} any{commit=string}
+// @failure     400              {object} any{error=string}
+// @failure     403              {object} any{error=string}
+// @failure     404              {object} any{error=string}
+// @failure     409              {object} any{error=string}
+// @failure     422              {object} any{error=string,messages=[]string}
+// @failure     500              {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/commit [post]
+func HandleProblemCommit(c *gin.Context) {
+	userID, _ := c.MustGet("_user").(uuid.UUID)
+
+	params := problemCommitReq{Branch: "main"}
+
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mp, prob, ok := getProblem(c, model.RoleEditor)
+	if !ok {
+		return
+	}
+
+	// The new commit is not built yet.
+	protection, err := model.GetBranchProtection(db.PDB, mp, params.Branch)
+	if err != nil {
+		log.WithError(err).Error("failed to get branch protection")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get branch protection"})
+		return
+	}
+	if protection != nil && protection.RequireBuild {
+		c.JSON(http.StatusConflict, gin.H{"error": "branch requires a successful build"})
+		return
+	}
+
+	user, err := model.GetUserById(db.PDB, userID)
+	if err != nil {
+		log.WithError(err).Error("failed to get user")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+		return
+	}
+
+	repo, err := prob.Repo()
+	if err != nil {
+		log.WithError(err).Error("failed to get problem repo")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem repo"})
+		return
+	}
+
+	base, err := repo.ResolveRevision(plumbing.Revision(params.Base))
+	if err != nil {
+		log.WithError(err).Error("failed to resolve revision")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to resolve revision"})
+		return
+	}
+
+	// The commit is checked like a push of it, see preReceive.
+	branch := plumbing.NewBranchReferenceName(params.Branch)
+	var messages []string
+	check := func(repo *gogit.Repository, hash [20]byte) error {
+		checks, err := newReceiveChecks(mp, &git.ReceiveRequest{
+			Commands: []git.ReceiveCommand{{Old: *base, New: hash, Ref: branch}},
+		})
+		if err != nil {
+			return err
+		}
+		if messages = checks.run(repo); len(messages) > 0 {
+			return problem.ErrCommitDeclined
+		}
+		return nil
+	}
+
+	hash, err := prob.Commit(branch, *base, params.Operations, params.Message,
+		&object.Signature{Name: user.Name, Email: user.Email, When: time.Now()}, check)
+	if errors.Is(err, problem.ErrCommitDeclined) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "commit declined", "messages": messages})
+		return
+	}
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "branch not found"})
+		return
+	}
+	if errors.Is(err, problem.ErrBranchMoved) {
+		c.JSON(http.StatusConflict, gin.H{"error": "branch moved"})
+		return
+	}
+	if errors.Is(err, problem.ErrInvalidFileOperation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to commit")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to commit"})
+		return
+	}
+
+	postReceive(mp, &git.ReceiveRequest{
+		Commands: []git.ReceiveCommand{{Old: *base, New: hash, Ref: branch}},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"commit": plumbing.Hash(hash).String()})
+}
+
+// @summary     ProblemDiff
+// @description Diff two revisions of a problem.
+// @description The config diff is null if any config fails to parse,
+// @description and the build diff is null if any revision is not built.
+// @tags        problem
+// @produce     json
+// @param       id   path     string true  "Problem ID"
+// @param       from query    string true  "Commit hash of the old revision"
+// @param       to   query    string false "Commit hash of the new revision, HEAD by default"
+// @success     200  {object} any{files=[]problem.FileDiff,config=problem.ConfigDiff,build=problem.BuildDiff}
+// @failure     400  {object} any{error=string}
+// @failure     403  {object} any{error=string}
+// @failure     404  {object} any{error=string}
+// @failure     500  {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/diff [get]
+func HandleProblemDiff(c *gin.Context) {
+	fromStr, ok := c.GetQuery("from")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+		return
+	}
+	toStr := c.DefaultQuery("to", "HEAD")
+
+	mp, prob, ok := getProblem(c, model.RoleViewer)
+	if !ok {
+		return
+	}
+
+	repo, err := prob.Repo()
+	if err != nil {
+		log.WithError(err).Error("failed to get problem repo")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem repo"})
+		return
+	}
+
+	from, err := repo.ResolveRevision(plumbing.Revision(fromStr))
+	if err != nil {
+		log.WithError(err).Error("failed to resolve revision")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to resolve revision"})
+		return
+	}
+	to, err := repo.ResolveRevision(plumbing.Revision(toStr))
+	if err != nil {
+		log.WithError(err).Error("failed to resolve revision")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to resolve revision"})
+		return
+	}
+
+	files, err := prob.DiffFiles(*from, *to)
+	if err != nil {
+		log.WithError(err).Error("failed to diff problem files")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to diff problem files"})
+		return
+	}
+
+	var configDiff *problem.ConfigDiff
+	fromConf, fromErr := prob.GetConfig(*from)
+	toConf, toErr := prob.GetConfig(*to)
+	if fromErr == nil && toErr == nil {
+		configDiff = problem.DiffConfig(fromConf, toConf)
+	}
+
+	// A pending or running build has no info.
+	var buildDiff *problem.BuildDiff
+	fromBuild, fromErr := model.GetBuildInfo(db.PDB, mp, *from)
+	toBuild, toErr := model.GetBuildInfo(db.PDB, mp, *to)
+	if fromErr == nil && toErr == nil && fromBuild.Info.Parse != nil && toBuild.Info.Parse != nil {
+		buildDiff = problem.DiffBuild(&fromBuild.Info, &toBuild.Info)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"files": files, "config": configDiff, "build": buildDiff})
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,18 +12,26 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"rindag/model"
 	"rindag/service/db"
 	"rindag/service/git"
+	"rindag/service/judge"
 	"rindag/service/problem"
 	"rindag/utils"
 
 	"github.com/gin-gonic/gin"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
+// MaxPushSize is the maximum size of the pack of a push validated before receiving it,
+// which is read into memory, see preReceive.
+const MaxPushSize = 256 * 1024 * 1024
+
 var routes = []struct {
 	re      *regexp.Regexp
 	handler func(*gin.Context, string, string)
@@ -46,39 +55,53 @@ func packetWrite(str string) []byte {
 	return []byte(s + str)
 }
 
-// getRepo returns the repo path or creates a new repo.
-// The first return value is the path of the repo.
-// The second return value is true if no error occurs.
-func getRepo(c *gin.Context) (string, bool) {
+// getRepo returns the repo path or creates a new repo,
+// if the current user has the role in the problem of the repo.
+// The first return value is the problem of the repo.
+// The second return value is the path of the repo.
+// The third return value is true if no error occurs.
+func getRepo(c *gin.Context, role model.Role) (*model.Problem, string, bool) {
 	// Remove redundant suffix ".git".
 	repoName := strings.TrimSuffix(c.Param("repo"), ".git")
 	if repoName == "" {
 		log.Warn("repo name is empty")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "repo is required"})
-		return "", false
+		return nil, "", false
 	}
 
 	problemID, err := uuid.Parse(repoName)
 	if err != nil {
 		log.WithError(err).WithField("repoName", repoName).Warn("repo name is not a valid uuid")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "repo is not a valid uuid"})
-		return "", false
+		return nil, "", false
 	}
 
-	if _, err := model.GetProblemByID(db.PDB, problemID); err != nil {
+	mp, err := model.GetProblemByID(db.PDB, problemID)
+	if err != nil {
 		log.WithError(err).Error("failed to get problem")
 		c.JSON(http.StatusNotFound, gin.H{"error": "repo is not found"})
-		return "", false
+		return nil, "", false
+	}
+
+	if !requireRole(c, mp, role) {
+		return nil, "", false
+	}
+
+	// A personal access token can only push with model.ScopeWriteRepository.
+	if token := accessToken(c); token != nil && role.Includes(model.RoleEditor) &&
+		!token.HasScope(model.ScopeWriteRepository) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access token can not push"})
+		return nil, "", false
 	}
 
 	prob := problem.NewProblem(problemID)
 	if _, err := prob.Repo(); err != nil {
 		log.WithError(err).Error("failed to get or init repo")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return "", false
+		return nil, "", false
 	}
 
-	return git.GetRepoPath(problemID.String()), true
+	return mp, git.GetRepoPath(problemID.String()), true
 }
 
 // handleRPC handles the git rpc.
@@ -104,18 +127,38 @@ func handleRPC(c *gin.Context, service string) {
 		}
 	}
 
-	repoPath, ok := getRepo(c)
+	// Viewers can clone the repo, but only editors can push to it.
+	role := model.RoleViewer
+	if service == "receive-pack" {
+		role = model.RoleEditor
+	}
+	mp, repoPath, ok := getRepo(c, role)
 	if !ok {
 		return
 	}
 
+	// Read the reference updates of a push, to react to them before and after receiving.
+	var req *git.ReceiveRequest
+	var stdin io.Reader = reqBody
+	if service == "receive-pack" {
+		req, err = git.ReadReceiveRequest(reqBody)
+		if err != nil {
+			log.WithError(err).Warn("failed to read receive-pack commands")
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if stdin, ok = preReceive(c, mp, req, reqBody); !ok {
+			return
+		}
+	}
+
 	// Set this for allow pre-receive and post-receive execute.
 	env := os.Environ()
 	env = append(env, "SSH_ORIGINAL_COMMAND="+service)
 
 	var stderr bytes.Buffer
 	cmd, pipe := git.NewCommand(repoPath, service, "--stateless-rpc", repoPath)
-	cmd.Stdin = reqBody
+	cmd.Stdin = stdin
 	cmd.Env = env
 	cmd.Stderr = &stderr
 
@@ -132,6 +175,253 @@ func handleRPC(c *gin.Context, service string) {
 		log.WithError(err).Error("failed to wait")
 		return
 	}
+
+	if req != nil {
+		postReceive(mp, req)
+	}
+}
+
+// preReceive validates the updates of branches in a push before receiving it,
+// like a pre-receive hook.
+//
+// A push is rejected if the config of the new tip of git.MainBranch fails BuildParse,
+// or it breaks the rules of any protected branch, see checkProtection.
+// The commits before the new tip are not parsed.
+// The errors are shown in the git client.
+// Returns the request to pass to "git receive-pack", and false if a response is written.
+func preReceive(
+	c *gin.Context, mp *model.Problem, req *git.ReceiveRequest, pack io.Reader,
+) (io.Reader, bool) {
+	checks, err := newReceiveChecks(mp, req)
+	if err != nil {
+		log.WithError(err).Error("failed to get branch protection")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get branch protection"})
+		return nil, false
+	}
+	if len(checks.commands) == 0 {
+		return req.Request(pack), true
+	}
+
+	data, err := io.ReadAll(io.LimitReader(pack, MaxPushSize+1))
+	if err != nil {
+		log.WithError(err).Error("failed to read pack")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read pack"})
+		return nil, false
+	}
+	if len(data) > MaxPushSize {
+		c.JSON(http.StatusRequestEntityTooLarge,
+			gin.H{"error": fmt.Sprintf("pack is larger than %d bytes", MaxPushSize)})
+		return nil, false
+	}
+
+	repo, err := problem.NewProblem(mp.ID).Repo()
+	if err != nil {
+		log.WithError(err).Error("failed to get problem repo")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem repo"})
+		return nil, false
+	}
+	// A push of existing commits may have no pack.
+	if len(data) > 0 {
+		if repo, err = git.OpenWithPack(repo, bytes.NewReader(data)); err != nil {
+			log.WithError(err).Warn("failed to parse pack")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pack"})
+			return nil, false
+		}
+	}
+
+	messages := checks.run(repo)
+	if len(messages) == 0 {
+		return req.Request(bytes.NewReader(data)), true
+	}
+
+	c.Header("Content-Type", "application/x-git-receive-pack-result")
+	c.Status(http.StatusOK)
+	if err := git.WriteReceiveRejection(
+		c.Writer, req, "pre-receive hook declined", messages); err != nil {
+		log.WithError(err).Error("failed to write receive-pack result")
+	}
+	return nil, false
+}
+
+// receiveChecks are the commands of a push to validate before receiving it,
+// with the protections of their branches, see preReceive.
+type receiveChecks struct {
+	mp          *model.Problem
+	commands    []git.ReceiveCommand
+	protections map[plumbing.ReferenceName]*model.BranchProtection
+}
+
+// newReceiveChecks returns the checks of the commands of a push to the problem.
+func newReceiveChecks(mp *model.Problem, req *git.ReceiveRequest) (*receiveChecks, error) {
+	checks := &receiveChecks{
+		mp:          mp,
+		commands:    []git.ReceiveCommand{},
+		protections: map[plumbing.ReferenceName]*model.BranchProtection{},
+	}
+	for _, command := range req.Commands {
+		if !command.Ref.IsBranch() {
+			continue
+		}
+		protection, err := model.GetBranchProtection(db.PDB, mp, command.Ref.Short())
+		if err != nil {
+			return nil, err
+		}
+		if protection != nil {
+			checks.protections[command.Ref] = protection
+		}
+		if protection != nil || (command.Ref == git.MainBranch && !command.IsDelete()) {
+			checks.commands = append(checks.commands, command)
+		}
+	}
+	return checks, nil
+}
+
+// needPack returns true if any checked command reads the objects of the pack of the push.
+func (checks *receiveChecks) needPack() bool {
+	for _, command := range checks.commands {
+		if !command.IsDelete() {
+			return true
+		}
+	}
+	return false
+}
+
+// run returns the error messages of the commands breaking the rules.
+//
+// The repo should contain the objects of the push, see git.OpenWithPack.
+func (checks *receiveChecks) run(repo *gogit.Repository) []string {
+	prob := problem.NewProblem(checks.mp.ID).WithRepo(repo)
+
+	messages := []string{}
+	for _, command := range checks.commands {
+		if protection, ok := checks.protections[command.Ref]; ok {
+			if msg := checkProtection(repo, checks.mp, protection, command); msg != "" {
+				messages = append(messages, msg)
+				continue
+			}
+		}
+		if command.IsDelete() {
+			continue
+		}
+		if info := prob.BuildParse(command.New); !info.OK {
+			messages = append(messages, fmt.Sprintf("%s (%s): invalid config: %s",
+				command.Ref.Short(), command.New.String()[:7], info.Err))
+		}
+	}
+	return messages
+}
+
+// checkProtection returns the error message if the update of a protected branch breaks its rules,
+// otherwise an empty string.
+//
+// A protected branch can not be deleted. If its rules require, it can not be force-pushed,
+// and its new commit should be built successfully before the push.
+func checkProtection(
+	repo *gogit.Repository, mp *model.Problem, protection *model.BranchProtection,
+	command git.ReceiveCommand,
+) string {
+	branch := command.Ref.Short()
+	if command.IsDelete() {
+		return fmt.Sprintf("%s: protected branch can not be deleted", branch)
+	}
+
+	if protection.DenyForcePush && !command.Old.IsZero() {
+		forced := true
+		oldCommit, err := repo.CommitObject(command.Old)
+		if err == nil {
+			newCommit, err := repo.CommitObject(command.New)
+			if err == nil {
+				isAncestor, err := oldCommit.IsAncestor(newCommit)
+				forced = err != nil || !isAncestor
+			}
+		}
+		if forced {
+			return fmt.Sprintf("%s: force-push to protected branch is denied", branch)
+		}
+	}
+
+	if protection.RequireBuild {
+		info, err := model.GetBuildInfo(db.PDB, mp, command.New)
+		if err != nil || !info.Info.OK {
+			return fmt.Sprintf("%s (%s): commit is not built successfully, build it first",
+				branch, command.New.String()[:7])
+		}
+	}
+
+	return ""
+}
+
+// postReceive reacts to the reference updates of a push, like a post-receive hook.
+//
+// The new commits of git.MainBranch are built in background,
+// and the status of the build is recorded by the commit.
+func postReceive(mp *model.Problem, req *git.ReceiveRequest) {
+	for _, command := range req.Commands {
+		if command.Ref != git.MainBranch || command.IsDelete() {
+			continue
+		}
+
+		// The update may be rejected, like a non-fast-forward push.
+		repo, err := problem.NewProblem(mp.ID).Repo()
+		if err != nil {
+			log.WithError(err).Error("failed to get problem repo")
+			return
+		}
+		ref, err := repo.Reference(command.Ref, true)
+		if err != nil || ref.Hash() != command.New {
+			continue
+		}
+
+		if _, err := model.SetBuildStatus(
+			db.PDB, mp, command.New, model.BuildStatusPending); err != nil {
+			log.WithError(err).Error("failed to record build status")
+			continue
+		}
+		go buildPushed(mp, command.New, time.Now())
+	}
+}
+
+// buildPushed builds a commit of the problem pushed at pushTime, and saves the build.
+// If it succeeds, it becomes the last build unless a later push has been built.
+//
+// The build is listed in "GET /job" and can be cancelled.
+// Only the ID of mp is used, which may be changed during the build.
+func buildPushed(mp *model.Problem, rev plumbing.Hash, pushTime time.Time) {
+	logger := log.WithField("problem", mp.ID).WithField("rev", rev.String())
+
+	job := judge.NewJob(context.Background(), "build", mp.ID.String())
+	defer job.Finish()
+
+	if _, err := model.SetBuildStatus(db.PDB, mp, rev, model.BuildStatusRunning); err != nil {
+		logger.WithError(err).Error("failed to record build status")
+	}
+
+	prob := problem.NewProblem(mp.ID)
+	info, fs := prob.Build(job.Context(), rev)
+
+	if job.Cancelled() {
+		if _, err := model.SetBuildStatus(
+			db.PDB, mp, rev, model.BuildStatusCancelled); err != nil {
+			logger.WithError(err).Error("failed to record build status")
+		}
+		return
+	}
+
+	if _, err := model.UpdateBuildInfo(db.PDB, mp, rev, *info); err != nil {
+		logger.WithError(err).Error("failed to create build info")
+		return
+	}
+
+	if !info.OK {
+		logger.Info("pushed build failed")
+		return
+	}
+
+	if err := saveBuild(mp, prob, rev, pushTime, info, fs); err != nil {
+		logger.WithError(err).Error("failed to save build")
+		return
+	}
+	logger.Info("pushed build succeeded")
 }
 
 // handleGetInfoRefs returns the git info refs.
@@ -219,6 +509,8 @@ func HandleGitUploadPack(c *gin.Context) {
 // HandleGitReceivePack returns the git upload pack response.
 //
 // This API is used by the git client.
+// A push to the main branch is validated before receiving, see preReceive,
+// and starts a build of the new commit, see postReceive.
 func HandleGitReceivePack(c *gin.Context) {
 	handleRPC(c, "receive-pack")
 }
@@ -232,7 +524,11 @@ func HandleGitGet(c *gin.Context) {
 			continue
 		}
 		log.WithField("url", url).WithField("reg", route.re.String()).Debug("matched")
-		repoPath, ok := getRepo(c)
+		role := model.RoleViewer
+		if c.Query("service") == "git-receive-pack" {
+			role = model.RoleEditor
+		}
+		_, repoPath, ok := getRepo(c, role)
 		if !ok {
 			return
 		}
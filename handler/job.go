@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net/http"
+
+	"rindag/service/judge"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// @summary     JobList
+// @description List all running jobs, like builds of problems.
+// @tags        job
+// @produce     json
+// @success     200 {object} any{jobs=[]judge.Job}
+// @security    ApiKeyAuth
+// @router      /job [get]
+func HandleJobList(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"jobs": judge.ListJobs()})
+}
+
+// @summary     JobCancel
+// @description Cancel a running job. Its running tasks will be aborted and queued tasks will never start.
+// @tags        job
+// @produce     json
+// @param       id  path     string true "Job ID"
+// @success     200 {object} any{message=string}
+// @failure     400 {object} any{error=string}
+// @failure     404 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /job/{id} [delete]
+func HandleJobCancel(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if err := judge.CancelJob(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "OK"})
+}
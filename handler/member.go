@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"rindag/model"
+	"rindag/service/db"
+	"rindag/service/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// requireRole checks the current user has the role in the problem, see model.GetRole.
+//
+// If it fails, an error response is written.
+func requireRole(c *gin.Context, mp *model.Problem, role model.Role) bool {
+	userID, _ := c.MustGet("_user").(uuid.UUID)
+
+	userRole, err := model.GetRole(db.PDB, mp, userID)
+	if err != nil {
+		log.WithError(err).Error("failed to get role")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get role"})
+		return false
+	}
+
+	if !userRole.Includes(role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return false
+	}
+	return true
+}
+
+// @summary     ProblemMemberList
+// @description List the members of a problem and their roles.
+// @tags        problem
+// @produce     json
+// @param       id  path     string true "Problem ID"
+// @success     200 {object} any{members=[]model.ProblemMember}
+// @failure     400 {object} any{error=string}
+// @failure     403 {object} any{error=string}
+// @failure     404 {object} any{error=string}
+// @failure     500 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/members [get]
+func HandleProblemMemberList(c *gin.Context) {
+	mp, _, ok := getProblem(c, model.RoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := model.ListMembers(db.PDB, mp)
+	if err != nil {
+		log.WithError(err).Error("failed to list members")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+type problemMemberReq struct {
+	// User is the name or email of the user.
+	User string     `json:"user" binding:"required"`
+	Role model.Role `json:"role" binding:"required"`
+}
+
+// @summary     ProblemMemberSet
+// @description Add a member to a problem, or change the role of a member.
+// @description Only owners can manage the members, and the last owner can not be demoted.
+// @tags        problem
+// @accept      json
+// @produce     json
+// @param       id               path     string           true "Problem ID"
+// @param       problemMemberReq body     problemMemberReq true "The user and the role"
+// @success     200              {object} any{member=model.ProblemMember}
+// @failure     400              {object} any{error=string}
+// @failure     403              {object} any{error=string}
+// @failure     404              {object} any{error=string}
+// @failure     409              {object} any{error=string}
+// @failure     500              {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/members [put]
+func HandleProblemMemberSet(c *gin.Context) {
+	var params problemMemberReq
+
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !params.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role"})
+		return
+	}
+
+	mp, _, ok := getProblem(c, model.RoleOwner)
+	if !ok {
+		return
+	}
+
+	user, err := model.GetUser(db.PDB, params.User)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	member, err := model.SetMember(db.PDB, mp, user.ID, params.Role)
+	if errors.Is(err, model.ErrLastOwner) {
+		c.JSON(http.StatusConflict, gin.H{"error": "the last owner can not be demoted"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to set member")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"member": member})
+}
+
+// @summary     ProblemMemberDelete
+// @description Remove a member from a problem. Only owners can manage the members,
+// @description and the last owner can not be removed.
+// @tags        problem
+// @produce     json
+// @param       id   path     string true "Problem ID"
+// @param       user path     string true "User ID"
+// @success     200  {object} any
+// @failure     400  {object} any{error=string}
+// @failure     403  {object} any{error=string}
+// @failure     404  {object} any{error=string}
+// @failure     409  {object} any{error=string}
+// @failure     500  {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/members/{user} [delete]
+func HandleProblemMemberDelete(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+		return
+	}
+
+	mp, _, ok := getProblem(c, model.RoleOwner)
+	if !ok {
+		return
+	}
+
+	err = model.DeleteMember(db.PDB, mp, userID)
+	if errors.Is(err, model.ErrLastOwner) {
+		c.JSON(http.StatusConflict, gin.H{"error": "the last owner can not be removed"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to delete member")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// @summary     ProblemProtectionList
+// @description List the protected branches of a problem.
+// @tags        problem
+// @produce     json
+// @param       id  path     string true "Problem ID"
+// @success     200 {object} any{protections=[]model.BranchProtection}
+// @failure     400 {object} any{error=string}
+// @failure     403 {object} any{error=string}
+// @failure     404 {object} any{error=string}
+// @failure     500 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/protections [get]
+func HandleProblemProtectionList(c *gin.Context) {
+	mp, _, ok := getProblem(c, model.RoleViewer)
+	if !ok {
+		return
+	}
+
+	protections, err := model.ListBranchProtections(db.PDB, mp)
+	if err != nil {
+		log.WithError(err).Error("failed to list branch protections")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list branch protections"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"protections": protections})
+}
+
+// @summary     ProblemProtectionSet
+// @description Protect a branch of a problem with the rules. Only owners can protect branches.
+// @tags        problem
+// @accept      json
+// @produce     json
+// @param       id         path     string                 true "Problem ID"
+// @param       protection body     model.BranchProtection true "The branch and its rules"
+// @success     200        {object} any{protection=model.BranchProtection}
+// @failure     400        {object} any{error=string}
+// @failure     403        {object} any{error=string}
+// @failure     404        {object} any{error=string}
+// @failure     500        {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/protections [put]
+func HandleProblemProtectionSet(c *gin.Context) {
+	var protection model.BranchProtection
+
+	if err := c.ShouldBindJSON(&protection); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !problem.ValidRefName(protection.Branch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid branch"})
+		return
+	}
+
+	mp, _, ok := getProblem(c, model.RoleOwner)
+	if !ok {
+		return
+	}
+
+	protection.Problem = mp.ID
+	if err := model.SetBranchProtection(db.PDB, &protection); err != nil {
+		log.WithError(err).Error("failed to set branch protection")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set branch protection"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"protection": protection})
+}
+
+// @summary     ProblemProtectionDelete
+// @description Unprotect a branch of a problem. Only owners can unprotect branches.
+// @tags        problem
+// @produce     json
+// @param       id     path     string true "Problem ID"
+// @param       branch path     string true "Branch name"
+// @success     200    {object} any
+// @failure     400    {object} any{error=string}
+// @failure     403    {object} any{error=string}
+// @failure     404    {object} any{error=string}
+// @failure     500    {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/protections/{branch} [delete]
+func HandleProblemProtectionDelete(c *gin.Context) {
+	// The branch is a catch-all param with a leading "/".
+	branch := strings.TrimPrefix(c.Param("branch"), "/")
+
+	mp, _, ok := getProblem(c, model.RoleOwner)
+	if !ok {
+		return
+	}
+
+	if err := model.DeleteBranchProtection(db.PDB, mp, branch); err != nil {
+		log.WithError(err).Error("failed to delete branch protection")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete branch protection"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
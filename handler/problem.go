@@ -1,16 +1,27 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"rindag/model"
 	"rindag/service/db"
+	"rindag/service/etc"
+	"rindag/service/git"
+	"rindag/service/judge"
 	"rindag/service/problem"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 // @summary     ProblemList
@@ -40,7 +51,7 @@ type problemAddReq struct {
 }
 
 // @summary     ProblemAdd
-// @description Add a problem and returns its id.
+// @description Add a problem and returns its id. The current user becomes its owner.
 // @tags        problem
 // @accept      json
 // @produce     json
@@ -58,10 +69,39 @@ func HandleProblemAdd(c *gin.Context) {
 		return
 	}
 
-	problem, err := model.CreateProblem(db.PDB, params.Name, params.Tags)
+	// The creator owns the problem, and the main branch can not be force-pushed by default.
+	// The problem is created with them in a transaction, so it is never left without owners.
+	userID, _ := c.MustGet("_user").(uuid.UUID)
+	var (
+		problem *model.Problem
+		msg     string
+	)
+	err := db.PDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if problem, err = model.CreateProblem(tx, params.Name, params.Tags); err != nil {
+			msg = "failed to create problem"
+			return err
+		}
+		if _, err := model.SetMember(tx, problem, userID, model.RoleOwner); err != nil {
+			msg = "failed to set problem owner"
+			return err
+		}
+		if err := model.SetBranchProtection(tx, &model.BranchProtection{
+			Problem:       problem.ID,
+			Branch:        git.MainBranch.Short(),
+			DenyForcePush: true,
+		}); err != nil {
+			msg = "failed to protect main branch"
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		log.WithError(err).Error("failed to create problem")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create problem"})
+		if msg == "" {
+			msg = "failed to create problem"
+		}
+		log.WithError(err).Error(msg)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 		return
 	}
 
@@ -76,28 +116,19 @@ func HandleProblemAdd(c *gin.Context) {
 // @param       rev string   query  string false "Commit hash"
 // @success     200 {object} problem.Config
 // @failure     400 {object} any{error=string}
+// @failure     403 {object} any{error=string}
 // @failure     404 {object} any{error=string}
 // @failure     500 {object} any{error=string}
 // @security    ApiKeyAuth
 // @router      /problem/{id}/config [get]
 func HandleProblemConfigGet(c *gin.Context) {
-	idStr := c.Param("id")
 	revStr := c.DefaultQuery("rev", "HEAD")
 
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
-		return
-	}
-
-	if _, err := model.GetProblemByID(db.PDB, id); err != nil {
-		log.WithError(err).Error("failed to get problem")
-		c.JSON(http.StatusNotFound, gin.H{"error": "failed to get problem"})
+	_, problem, ok := getProblem(c, model.RoleViewer)
+	if !ok {
 		return
 	}
 
-	problem := problem.NewProblem(id)
-
 	repo, err := problem.Repo()
 	if err != nil {
 		log.WithError(err).Error("failed to get problem repo")
@@ -128,20 +159,21 @@ type problemBuildReq struct {
 }
 
 // @summary     ProblemBuild
-// @description Build a problem.
+// @description Build a problem. While building, the build is listed in "GET /job" and can be cancelled.
+// @description It requires the editor role.
 // @tags        problem
 // @produce     json
 // @param       id              path     string          true "Problem ID"
 // @param       problemBuildReq body     problemBuildReq true "Problem build request"
 // @success     200             {object} any{build=problem.BuildInfo}
 // @failure     400             {object} any{error=string}
+// @failure     403             {object} any{error=string}
 // @failure     404             {object} any{error=string}
+// @failure     409             {object} any{error=string}
 // @failure     500             {object} any{error=string}
 // @security    ApiKeyAuth
 // @router      /problem/{id}/build [post]
 func HandleProblemBuild(c *gin.Context) {
-	idStr := c.Param("id")
-
 	params := problemBuildReq{Rev: "HEAD"}
 
 	if err := c.ShouldBindJSON(&params); err != nil {
@@ -149,21 +181,11 @@ func HandleProblemBuild(c *gin.Context) {
 		return
 	}
 
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
-		return
-	}
-
-	mp, err := model.GetProblemByID(db.PDB, id)
-	if err != nil {
-		log.WithError(err).Error("failed to get problem")
-		c.JSON(http.StatusNotFound, gin.H{"error": "failed to get problem"})
+	mp, problem, ok := getProblem(c, model.RoleEditor)
+	if !ok {
 		return
 	}
 
-	problem := problem.NewProblem(id)
-
 	repo, err := problem.Repo()
 	if err != nil {
 		log.WithError(err).Error("failed to get problem repo")
@@ -178,7 +200,17 @@ func HandleProblemBuild(c *gin.Context) {
 		return
 	}
 
-	info, fs := problem.Build(*hash)
+	// The build can be cancelled by "DELETE /job/:id", or by closing the connection.
+	job := judge.NewJob(c.Request.Context(), "build", mp.ID.String())
+	defer job.Finish()
+
+	startTime := time.Now()
+	info, fs := problem.Build(job.Context(), *hash)
+
+	if job.Cancelled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "build cancelled"})
+		return
+	}
 
 	if !params.Save {
 		c.JSON(http.StatusOK, info)
@@ -197,30 +229,208 @@ func HandleProblemBuild(c *gin.Context) {
 		return
 	}
 
-	if err := problem.StorageSave(info.Generate.TestGroups, fs); err != nil {
-		log.WithError(err).Error("failed to save problem build files")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save problem build files"})
+	if err := saveBuild(mp, problem, *hash, startTime, info, fs); err != nil {
+		log.WithError(err).Error("failed to save build")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save build"})
 		return
 	}
 
 	c.JSON(http.StatusOK, info)
 }
 
+// saveBuild saves the tests of a successful build of the problem at rev started at startTime,
+// and sets it as the last build unless a later build has been saved, see model.SetLastBuild.
+//
+// The tests of the other builds, except the release, are removed.
+func saveBuild(
+	mp *model.Problem, prob *problem.Problem, rev [20]byte, startTime time.Time,
+	info *problem.BuildInfo, fs billy.Filesystem,
+) error {
+	if err := prob.StorageSave(rev, info.Generate.TestGroups, fs); err != nil {
+		return fmt.Errorf("failed to save problem build files: %w", err)
+	}
+
+	set, err := model.SetLastBuild(db.PDB, mp, rev, startTime)
+	if err != nil {
+		return fmt.Errorf("failed to set last build: %w", err)
+	}
+	if !set {
+		return nil
+	}
+
+	// The problem may be published during the build.
+	mp, err = model.GetProblemByID(db.PDB, mp.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get problem: %w", err)
+	}
+	keep := [][20]byte{rev}
+	if len(mp.ReleaseRev) == len(rev) {
+		keep = append(keep, mp.JudgeRev())
+	}
+	if err := prob.StorageClean(keep, startTime); err != nil {
+		log.WithError(err).WithField("problem", mp.ID).Warn("failed to clean problem build files")
+	}
+	return nil
+}
+
+// testsStored returns true if the tests of the build of the problem at rev are stored.
+//
+// The tests of the last build are always stored, even if they are saved before
+// the tests are stored by revisions, see problem.StorageSave.
+func testsStored(
+	mp *model.Problem, prob *problem.Problem, rev [20]byte, info *problem.BuildInfo,
+) (bool, error) {
+	if bytes.Equal(mp.LastBuildRev, rev[:]) {
+		return true, nil
+	}
+	return prob.StorageHas(rev, info.Generate.TestGroups)
+}
+
+// problemBuildItem is a build in the build history of a problem.
+type problemBuildItem struct {
+	Rev         string            `json:"rev"`
+	Message     string            `json:"message"`
+	Author      string            `json:"author"`
+	BuildTime   time.Time         `json:"build_time"`
+	Status      model.BuildStatus `json:"status"`
+	OK          bool              `json:"ok"`
+	FailedPhase string            `json:"failed_phase,omitempty"`
+	Duration    time.Duration     `json:"duration"`
+}
+
+// @summary     ProblemBuildList
+// @description List the builds of a problem from the latest one, with the commits they built.
+// @description The message and author are empty if the commit is no longer in the repo.
+// @tags        problem
+// @produce     json
+// @param       id   path     string true  "Problem ID"
+// @param       page query    int    false "Page number, starting from 1"
+// @param       size query    int    false "Page size, at most 100"
+// @success     200  {object} any{builds=[]problemBuildItem,total=int}
+// @failure     400  {object} any{error=string}
+// @failure     403  {object} any{error=string}
+// @failure     404  {object} any{error=string}
+// @failure     500  {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/builds [get]
+func HandleProblemBuildList(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if err != nil || size < 1 || size > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid size"})
+		return
+	}
+
+	mp, prob, ok := getProblem(c, model.RoleViewer)
+	if !ok {
+		return
+	}
+
+	repo, err := prob.Repo()
+	if err != nil {
+		log.WithError(err).Error("failed to get problem repo")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem repo"})
+		return
+	}
+
+	infos, total, err := model.ListBuildInfos(db.PDB, mp, (page-1)*size, size)
+	if err != nil {
+		log.WithError(err).Error("failed to list build infos")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list build infos"})
+		return
+	}
+
+	builds := make([]problemBuildItem, 0, len(infos))
+	for _, info := range infos {
+		var hash plumbing.Hash
+		copy(hash[:], info.Rev)
+		build := problemBuildItem{
+			Rev:         hash.String(),
+			BuildTime:   info.BuildTime,
+			Status:      info.Status,
+			OK:          info.Info.OK,
+			FailedPhase: info.Info.FailedPhase(),
+			Duration:    info.Info.Duration,
+		}
+		// The commit may be lost after a force push.
+		if commit, err := repo.CommitObject(hash); err == nil {
+			build.Message = commit.Message
+			build.Author = commit.Author.String()
+		}
+		builds = append(builds, build)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"builds": builds, "total": total})
+}
+
+// @summary     ProblemBuildGet
+// @description Get the stored build information of a problem at a revision.
+// @description The build is empty if it is pending or running.
+// @tags        problem
+// @produce     json
+// @param       id  path     string true "Problem ID"
+// @param       rev path     string true "Commit hash"
+// @success     200 {object} any{status=string,build=problem.BuildInfo}
+// @failure     400 {object} any{error=string}
+// @failure     403 {object} any{error=string}
+// @failure     404 {object} any{error=string}
+// @failure     500 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/builds/{rev} [get]
+func HandleProblemBuildGet(c *gin.Context) {
+	revStr := c.Param("rev")
+
+	mp, prob, ok := getProblem(c, model.RoleViewer)
+	if !ok {
+		return
+	}
+
+	repo, err := prob.Repo()
+	if err != nil {
+		log.WithError(err).Error("failed to get problem repo")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem repo"})
+		return
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(revStr))
+	if err != nil {
+		log.WithError(err).Error("failed to resolve revision")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to resolve revision"})
+		return
+	}
+
+	info, err := model.GetBuildInfo(db.PDB, mp, *hash)
+	if err != nil {
+		log.WithError(err).Error("failed to get build info")
+		c.JSON(http.StatusNotFound, gin.H{"error": "failed to get build info"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": info.Status, "build": info.Info})
+}
+
 // @summary     ProblemPackage
-// @description Package a problem.
+// @description Package a problem. If the revision is not specified, it will use the release,
+// @description or the last build if the problem is not published.
 // @tags        problem
 // @produce     application/zip
 // @param       id     path     string true "Problem ID"
 // @param       format query    string true "Package format"
 // @param       lang   query    string false "Package format"
+// @param       rev    query    string false "Commit hash of a build"
 // @success     200    {object} any
 // @failure     400    {object} any{error=string}
+// @failure     403    {object} any{error=string}
 // @failure     404    {object} any{error=string}
+// @failure     409    {object} any{error=string}
 // @failure     500    {object} any{error=string}
 // @security    ApiKeyAuth
 // @router      /problem/{id}/package [get]
 func HandleProblemPackage(c *gin.Context) {
-	idStr := c.Param("id")
 	format, ok := c.GetQuery("format")
 	if !ok {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format"})
@@ -228,38 +438,235 @@ func HandleProblemPackage(c *gin.Context) {
 	}
 	lang := c.DefaultQuery("lang", "en")
 
-	id, err := uuid.Parse(idStr)
+	mp, problem, ok := getProblem(c, model.RoleViewer)
+	if !ok {
+		return
+	}
+
+	rev := mp.JudgeRev()
+
+	if revStr, ok := c.GetQuery("rev"); ok {
+		repo, err := problem.Repo()
+		if err != nil {
+			log.WithError(err).Error("failed to get problem repo")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem repo"})
+			return
+		}
+
+		hash, err := repo.ResolveRevision(plumbing.Revision(revStr))
+		if err != nil {
+			log.WithError(err).Error("failed to resolve revision")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to resolve revision"})
+			return
+		}
+		rev = *hash
+	}
+
+	info, err := model.GetBuildInfo(db.PDB, mp, rev)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		log.WithError(err).Error("failed to get build info")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get build info"})
 		return
 	}
 
-	mp, err := model.GetProblemByID(db.PDB, id)
+	if info.Info.Generate == nil || !info.Info.Generate.OK {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "build failed"})
+		return
+	}
+
+	// Only the tests of the builds saved are stored, see saveBuild.
+	stored, err := testsStored(mp, problem, rev, &info.Info)
 	if err != nil {
-		log.WithError(err).Error("failed to get problem")
-		c.JSON(http.StatusNotFound, gin.H{"error": "failed to get problem"})
+		log.WithError(err).Error("failed to check problem build files")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check problem build files"})
+		return
+	}
+	if !stored {
+		c.JSON(http.StatusConflict, gin.H{"error": "tests of the revision are not stored"})
 		return
 	}
 
-	problem := problem.NewProblem(id)
+	if err := problem.Package(
+		format, lang, rev, info.Info.Parse.Config, info.Info.Generate.TestGroups, c.Writer,
+	); err != nil {
+		log.WithError(err).Error("failed to package problem")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to package problem"})
+		return
+	}
+}
+
+type problemSubmitReq struct {
+	Code     string `json:"code" binding:"required"`
+	Language string `json:"language" binding:"required"`
+}
+
+// getJudgeBuild returns the problem and the info of its build used to judge, which should be
+// successful, see model.Problem.JudgeRev. The current user should be a viewer of the problem.
+//
+// If it fails, an error response is written.
+func getJudgeBuild(c *gin.Context) (*problem.Problem, [20]byte, *model.BuildInfo, bool) {
 	var rev [20]byte
-	copy(rev[:], mp.LastBuildRev[0:20])
+
+	mp, prob, ok := getProblem(c, model.RoleViewer)
+	if !ok {
+		return nil, rev, nil, false
+	}
+
+	rev = mp.JudgeRev()
 
 	info, err := model.GetBuildInfo(db.PDB, mp, rev)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "problem is not built"})
+		return nil, rev, nil, false
+	}
 	if err != nil {
 		log.WithError(err).Error("failed to get build info")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get build info"})
+		return nil, rev, nil, false
+	}
+
+	if !info.Info.OK {
+		c.JSON(http.StatusConflict, gin.H{"error": "build failed"})
+		return nil, rev, nil, false
+	}
+
+	return prob, rev, info, true
+}
+
+// @summary     ProblemSubmit
+// @description Judge a submission on the test cases of the release of a problem,
+// @description or its last build if it is not published.
+// @description For output-only problems, upload a zip file of "<test>.out" files as "outputs".
+// @description While judging, the submission is listed in "GET /job" and can be cancelled.
+// @tags        problem
+// @accept      json,mpfd
+// @produce     json
+// @param       id               path     string           true  "Problem ID"
+// @param       problemSubmitReq body     problemSubmitReq false "Submission"
+// @param       outputs          formData file             false "Zip file of outputs"
+// @success     200              {object} problem.SubmitResult
+// @failure     400              {object} any{error=string}
+// @failure     403              {object} any{error=string}
+// @failure     404              {object} any{error=string}
+// @failure     409              {object} any{error=string}
+// @failure     500              {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/submit [post]
+func HandleProblemSubmit(c *gin.Context) {
+	prob, rev, info, ok := getJudgeBuild(c)
+	if !ok {
 		return
 	}
+	conf := info.Info.Parse.Config
+	testGroups := info.Info.Generate.TestGroups
+
+	var submit func(ctx context.Context) *problem.SubmitResult
+
+	if conf.Type == problem.ProblemTypeOutputOnly {
+		header, err := c.FormFile("outputs")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+		outputs, err := problem.ReadOutputsZip(file, header.Size)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		submit = func(ctx context.Context) *problem.SubmitResult {
+			return prob.SubmitOutputs(ctx, rev, conf, testGroups, outputs)
+		}
+	} else {
+		var params problemSubmitReq
+		if err := c.ShouldBindJSON(&params); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, ok := etc.Config.Languages[params.Language]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown language"})
+			return
+		}
+		grader, err := prob.GetGrader(rev, conf, params.Language)
+		if err != nil {
+			log.WithError(err).Error("failed to get grader")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get grader"})
+			return
+		}
+		// Submissions without a grader can not link, if the problem has graders.
+		if grader == nil && len(conf.Graders) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no grader for language"})
+			return
+		}
+		headers, err := prob.GetHeaders(rev, conf)
+		if err != nil {
+			log.WithError(err).Error("failed to get headers")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get headers"})
+			return
+		}
+		solution := problem.NewSolutionFromBytes([]byte(params.Code)).
+			WithLanguage(params.Language).
+			WithGrader(grader).
+			WithHeaders(headers)
+		submit = func(ctx context.Context) *problem.SubmitResult {
+			return prob.Submit(ctx, rev, conf, testGroups, solution)
+		}
+	}
 
-	if !info.Info.Generate.OK {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "last build failed"})
+	// The judging can be cancelled by "DELETE /job/:id", or by closing the connection.
+	job := judge.NewJob(c.Request.Context(), "submit", prob.ID.String())
+	defer job.Finish()
+
+	result := submit(job.Context())
+
+	if job.Cancelled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "judge cancelled"})
 		return
 	}
 
-	if err := problem.Package(format, lang, info.Info.Generate.TestGroups, c.Writer); err != nil {
-		log.WithError(err).Error("failed to package problem")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to package problem"})
+	if !result.OK {
+		log.WithField("error", result.Err).Error("failed to judge submission")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to judge submission"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// @summary     ProblemInputs
+// @description Download the inputs of the release or the last build of an output-only problem.
+// @tags        problem
+// @produce     application/zip
+// @param       id  path     string true "Problem ID"
+// @success     200 {object} any
+// @failure     400 {object} any{error=string}
+// @failure     403 {object} any{error=string}
+// @failure     404 {object} any{error=string}
+// @failure     409 {object} any{error=string}
+// @failure     500 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/inputs [get]
+func HandleProblemInputs(c *gin.Context) {
+	prob, rev, info, ok := getJudgeBuild(c)
+	if !ok {
+		return
+	}
+
+	if info.Info.Parse.Config.Type != problem.ProblemTypeOutputOnly {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not an output-only problem"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment; filename=inputs.zip")
+	if err := prob.PackageInputs(rev, info.Info.Generate.TestGroups, c.Writer); err != nil {
+		log.WithError(err).Error("failed to package inputs")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to package inputs"})
 		return
 	}
 }
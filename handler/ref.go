@@ -0,0 +1,334 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"rindag/model"
+	"rindag/service/db"
+	"rindag/service/git"
+	"rindag/service/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// getProblem returns the problem in database and the problem of the "id" param,
+// if the current user has the role in it.
+//
+// If it fails, an error response is written.
+func getProblem(c *gin.Context, role model.Role) (*model.Problem, *problem.Problem, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, nil, false
+	}
+
+	mp, err := model.GetProblemByID(db.PDB, id)
+	if err != nil {
+		log.WithError(err).Error("failed to get problem")
+		c.JSON(http.StatusNotFound, gin.H{"error": "failed to get problem"})
+		return nil, nil, false
+	}
+
+	if !requireRole(c, mp, role) {
+		return nil, nil, false
+	}
+
+	return mp, problem.NewProblem(id), true
+}
+
+// handleRefList lists the branches or tags of a problem.
+func handleRefList(c *gin.Context, list func(*problem.Problem) ([]problem.Ref, error)) {
+	_, prob, ok := getProblem(c, model.RoleViewer)
+	if !ok {
+		return
+	}
+
+	refs, err := list(prob)
+	if err != nil {
+		log.WithError(err).Error("failed to list refs")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list refs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"refs": refs})
+}
+
+type problemRefReq struct {
+	Name string `json:"name" binding:"required"`
+	Rev  string `json:"rev" default:"HEAD"`
+}
+
+// handleRefCreate creates a branch or tag of a problem.
+func handleRefCreate(c *gin.Context, refName func(string) plumbing.ReferenceName) {
+	params := problemRefReq{Rev: "HEAD"}
+
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, prob, ok := getProblem(c, model.RoleEditor)
+	if !ok {
+		return
+	}
+
+	repo, err := prob.Repo()
+	if err != nil {
+		log.WithError(err).Error("failed to get problem repo")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get problem repo"})
+		return
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(params.Rev))
+	if err != nil {
+		log.WithError(err).Error("failed to resolve revision")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to resolve revision"})
+		return
+	}
+
+	err = prob.CreateRef(refName(params.Name), *hash)
+	if errors.Is(err, problem.ErrInvalidRefName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid name"})
+		return
+	}
+	if errors.Is(err, problem.ErrRefExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "ref already exists"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to create ref")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create ref"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ref": problem.Ref{Name: params.Name, Commit: hash.String()}})
+}
+
+// handleRefDelete deletes a branch or tag of a problem.
+//
+// The ref is not deleted if protected returns true, like the main branch.
+func handleRefDelete(
+	c *gin.Context, refName func(string) plumbing.ReferenceName,
+	protected func(*model.Problem, plumbing.ReferenceName) bool,
+) {
+	// The name is a catch-all param with a leading "/".
+	name := refName(strings.TrimPrefix(c.Param("name"), "/"))
+
+	mp, prob, ok := getProblem(c, model.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if protected(mp, name) {
+		c.JSON(http.StatusConflict, gin.H{"error": "ref is protected"})
+		return
+	}
+
+	err := prob.DeleteRef(name)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ref not found"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to delete ref")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete ref"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// @summary     ProblemBranchList
+// @description List the branches of a problem.
+// @tags        problem
+// @produce     json
+// @param       id  path     string true "Problem ID"
+// @success     200 {object} any{refs=[]problem.Ref}
+// @failure     400 {object} any{error=string}
+// @failure     403 {object} any{error=string}
+// @failure     404 {object} any{error=string}
+// @failure     500 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/branches [get]
+func HandleProblemBranchList(c *gin.Context) {
+	handleRefList(c, (*problem.Problem).Branches)
+}
+
+// @summary     ProblemBranchCreate
+// @description Create a branch of a problem, like a draft branch.
+// @tags        problem
+// @accept      json
+// @produce     json
+// @param       id            path     string        true "Problem ID"
+// @param       problemRefReq body     problemRefReq true "The name and the revision of the branch"
+// @success     200           {object} any{ref=problem.Ref}
+// @failure     400           {object} any{error=string}
+// @failure     403           {object} any{error=string}
+// @failure     404           {object} any{error=string}
+// @failure     409           {object} any{error=string}
+// @failure     500           {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/branches [post]
+func HandleProblemBranchCreate(c *gin.Context) {
+	handleRefCreate(c, plumbing.NewBranchReferenceName)
+}
+
+// @summary     ProblemBranchDelete
+// @description Delete a branch of a problem. The main branch and protected branches can not be
+// @description deleted.
+// @tags        problem
+// @produce     json
+// @param       id   path     string true "Problem ID"
+// @param       name path     string true "Branch name"
+// @success     200  {object} any
+// @failure     400  {object} any{error=string}
+// @failure     403  {object} any{error=string}
+// @failure     404  {object} any{error=string}
+// @failure     409  {object} any{error=string}
+// @failure     500  {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/branches/{name} [delete]
+func HandleProblemBranchDelete(c *gin.Context) {
+	handleRefDelete(c, plumbing.NewBranchReferenceName,
+		func(mp *model.Problem, name plumbing.ReferenceName) bool {
+			if name == git.MainBranch {
+				return true
+			}
+			protection, err := model.GetBranchProtection(db.PDB, mp, name.Short())
+			return err != nil || protection != nil
+		})
+}
+
+// @summary     ProblemTagList
+// @description List the tags of a problem.
+// @tags        problem
+// @produce     json
+// @param       id  path     string true "Problem ID"
+// @success     200 {object} any{refs=[]problem.Ref}
+// @failure     400 {object} any{error=string}
+// @failure     403 {object} any{error=string}
+// @failure     404 {object} any{error=string}
+// @failure     500 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/tags [get]
+func HandleProblemTagList(c *gin.Context) {
+	handleRefList(c, (*problem.Problem).Tags)
+}
+
+// @summary     ProblemTagCreate
+// @description Create a tag of a problem, like "v1" or "contest-2026".
+// @tags        problem
+// @accept      json
+// @produce     json
+// @param       id            path     string        true "Problem ID"
+// @param       problemRefReq body     problemRefReq true "The name and the revision of the tag"
+// @success     200           {object} any{ref=problem.Ref}
+// @failure     400           {object} any{error=string}
+// @failure     403           {object} any{error=string}
+// @failure     404           {object} any{error=string}
+// @failure     409           {object} any{error=string}
+// @failure     500           {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/tags [post]
+func HandleProblemTagCreate(c *gin.Context) {
+	handleRefCreate(c, plumbing.NewTagReferenceName)
+}
+
+// @summary     ProblemTagDelete
+// @description Delete a tag of a problem. The published tag can not be deleted.
+// @tags        problem
+// @produce     json
+// @param       id   path     string true "Problem ID"
+// @param       name path     string true "Tag name"
+// @success     200  {object} any
+// @failure     400  {object} any{error=string}
+// @failure     403  {object} any{error=string}
+// @failure     404  {object} any{error=string}
+// @failure     409  {object} any{error=string}
+// @failure     500  {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/tags/{name} [delete]
+func HandleProblemTagDelete(c *gin.Context) {
+	handleRefDelete(c, plumbing.NewTagReferenceName,
+		func(mp *model.Problem, name plumbing.ReferenceName) bool {
+			return mp.ReleaseTag != "" && name == plumbing.NewTagReferenceName(mp.ReleaseTag)
+		})
+}
+
+type problemPublishReq struct {
+	Tag string `json:"tag" binding:"required"`
+}
+
+// @summary     ProblemPublish
+// @description Publish a tag of a problem as its release, which is used by packaging and judging.
+// @description Only owners can publish problems.
+// @description The commit of the tag should be built successfully,
+// @description and the release does not change if the tag is moved later.
+// @tags        problem
+// @accept      json
+// @produce     json
+// @param       id                path     string            true "Problem ID"
+// @param       problemPublishReq body     problemPublishReq true "The tag to publish"
+// @success     200               {object} any{release=problem.Ref}
+// @failure     400               {object} any{error=string}
+// @failure     403               {object} any{error=string}
+// @failure     404               {object} any{error=string}
+// @failure     409               {object} any{error=string}
+// @failure     500               {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /problem/{id}/publish [post]
+func HandleProblemPublish(c *gin.Context) {
+	var params problemPublishReq
+
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mp, prob, ok := getProblem(c, model.RoleOwner)
+	if !ok {
+		return
+	}
+
+	rev, err := prob.ResolveRef(plumbing.NewTagReferenceName(params.Tag))
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to resolve tag")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve tag"})
+		return
+	}
+
+	info, err := model.GetBuildInfo(db.PDB, mp, rev)
+	if err != nil || !info.Info.OK {
+		c.JSON(http.StatusConflict, gin.H{"error": "tag is not built successfully"})
+		return
+	}
+	stored, err := testsStored(mp, prob, rev, &info.Info)
+	if err != nil {
+		log.WithError(err).Error("failed to check problem build files")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check problem build files"})
+		return
+	}
+	if !stored {
+		c.JSON(http.StatusConflict, gin.H{"error": "tests of the tag are not stored, build it with save"})
+		return
+	}
+
+	if err := model.PublishRelease(db.PDB, mp, params.Tag, rev); err != nil {
+		log.WithError(err).Error("failed to publish release")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to publish release"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"release": problem.Ref{Name: params.Tag, Commit: plumbing.Hash(rev).String()},
+	})
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"rindag/model"
+	"rindag/service/db"
+	"rindag/service/git"
+	"rindag/service/problem"
+	"rindag/utils"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// NewSSHServerConfig returns the config of the ssh server for git with the host key,
+// which authenticates users by their ssh keys, see model.SSHKey.
+//
+// The ID of the user is "user" in the extensions of the permissions.
+func NewSSHServerConfig(hostKey ssh.Signer) *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(
+			conn ssh.ConnMetadata, key ssh.PublicKey,
+		) (*ssh.Permissions, error) {
+			record, err := model.AuthenticateSSHKey(db.PDB, key)
+			if err != nil {
+				log.WithError(err).WithField("addr", conn.RemoteAddr()).
+					Debug("failed to authenticate ssh key")
+				return nil, errors.New("unknown public key")
+			}
+			return &ssh.Permissions{
+				Extensions: map[string]string{"user": record.User.String()},
+			}, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	return config
+}
+
+// sshError writes the error message to the git client, and returns the exit status.
+func sshError(ch ssh.Channel, msg string) uint32 {
+	fmt.Fprintf(ch.Stderr(), "rindag: %s\n", msg)
+	return 1
+}
+
+// HandleSSHGit runs "git upload-pack" or "git receive-pack" for a git client over ssh.
+//
+// The permissions are the same as the http handlers, see handleRPC.
+func HandleSSHGit(req *git.SSHRequest, ch ssh.Channel) uint32 {
+	userID, err := uuid.Parse(req.Permissions.Extensions["user"])
+	if err != nil {
+		return sshError(ch, "unknown user")
+	}
+
+	problemID, err := uuid.Parse(req.Repo)
+	if err != nil {
+		return sshError(ch, "repo is not a valid uuid")
+	}
+
+	mp, err := model.GetProblemByID(db.PDB, problemID)
+	if err != nil {
+		return sshError(ch, "repo is not found")
+	}
+
+	// Viewers can clone the repo, but only editors can push to it.
+	role := model.RoleViewer
+	if req.Service == "receive-pack" {
+		role = model.RoleEditor
+	}
+	userRole, err := model.GetRole(db.PDB, mp, userID)
+	if err != nil {
+		log.WithError(err).Error("failed to get role")
+		return sshError(ch, "failed to get role")
+	}
+	if !userRole.Includes(role) {
+		return sshError(ch, "permission denied")
+	}
+
+	if _, err := problem.NewProblem(problemID).Repo(); err != nil {
+		log.WithError(err).Error("failed to get or init repo")
+		return sshError(ch, "failed to get repo")
+	}
+	repoPath := git.GetRepoPath(problemID.String())
+
+	var stderr bytes.Buffer
+	cmd, stdout := git.NewCommand(repoPath, req.Service, repoPath)
+	cmd.Env = append(cmd.Env, "SSH_ORIGINAL_COMMAND="+req.Service)
+	cmd.Env = append(cmd.Env, req.Env...)
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return sshError(ch, err.Error())
+	}
+
+	if err := cmd.Start(); err != nil {
+		log.WithError(err).Error("failed to start git")
+		return sshError(ch, "failed to start git")
+	}
+	defer utils.CleanUpProcessGroup(cmd)
+
+	// The refs are advertised before the client sends its request.
+	done := make(chan struct{})
+	go func() {
+		io.Copy(ch, stdout)
+		close(done)
+	}()
+
+	var input io.Reader = ch
+	var receive *git.ReceiveRequest
+	if req.Service == "receive-pack" {
+		var messages []string
+		receive, input, messages, err = sshPreReceive(mp, ch)
+		if err != nil || len(messages) > 0 {
+			stdin.Close()
+			<-done
+			cmd.Wait()
+			if err != nil {
+				return sshError(ch, err.Error())
+			}
+			if err := git.WriteReceiveRejection(
+				ch, receive, "pre-receive hook declined", messages); err != nil {
+				log.WithError(err).Error("failed to write receive-pack result")
+			}
+			return 0
+		}
+	}
+
+	go func() {
+		io.Copy(stdin, input)
+		stdin.Close()
+	}()
+	<-done
+
+	if err := cmd.Wait(); err != nil {
+		log.WithError(err).WithField("stderr", stderr.String()).Error("failed to wait")
+		ch.Stderr().Write(stderr.Bytes())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return uint32(exitErr.ExitCode())
+		}
+		return 1
+	}
+
+	if receive != nil {
+		postReceive(mp, receive)
+	}
+	return 0
+}
+
+// sshPreReceive reads the request of a push over ssh and validates it, see preReceive.
+//
+// Returns the request and the input to pass to "git receive-pack",
+// or the error messages if the push is declined.
+func sshPreReceive(
+	mp *model.Problem, ch ssh.Channel,
+) (*git.ReceiveRequest, io.Reader, []string, error) {
+	req, err := git.ReadReceiveRequest(ch)
+	if err != nil {
+		log.WithError(err).Warn("failed to read receive-pack commands")
+		return nil, nil, nil, errors.New("invalid receive-pack request")
+	}
+
+	checks, err := newReceiveChecks(mp, req)
+	if err != nil {
+		log.WithError(err).Error("failed to get branch protection")
+		return nil, nil, nil, errors.New("failed to get branch protection")
+	}
+	if len(checks.commands) == 0 {
+		return req, req.Request(ch), nil, nil
+	}
+
+	repo, err := problem.NewProblem(mp.ID).Repo()
+	if err != nil {
+		log.WithError(err).Error("failed to get problem repo")
+		return nil, nil, nil, errors.New("failed to get problem repo")
+	}
+
+	// The client keeps the connection open after the pack, so it is parsed while it is read,
+	// and the read data is passed to git. A push of deletions only has no pack.
+	var pack io.Reader = ch
+	if checks.needPack() {
+		data := &bytes.Buffer{}
+		limited := io.LimitReader(ch, MaxPushSize+1)
+		if repo, err = git.OpenWithPack(repo, io.TeeReader(limited, data)); err != nil {
+			if data.Len() > MaxPushSize {
+				return nil, nil, nil, fmt.Errorf("pack is larger than %d bytes", MaxPushSize)
+			}
+			log.WithError(err).Warn("failed to parse pack")
+			return nil, nil, nil, errors.New("invalid pack")
+		}
+		pack = io.MultiReader(data, ch)
+	}
+
+	return req, req.Request(pack), checks.run(repo), nil
+}
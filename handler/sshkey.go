@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"rindag/model"
+	"rindag/service/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// @summary     SSHKeyList
+// @description List the ssh keys of the current user.
+// @tags        account
+// @produce     json
+// @success     200 {object} any{keys=[]model.SSHKey}
+// @failure     403 {object} any{error=string}
+// @failure     500 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /key [get]
+func HandleSSHKeyList(c *gin.Context) {
+	userID, _ := c.MustGet("_user").(uuid.UUID)
+
+	if !requireSession(c) {
+		return
+	}
+
+	keys, err := model.ListSSHKeys(db.PDB, userID)
+	if err != nil {
+		log.WithError(err).Error("failed to list ssh keys")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list ssh keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+type sshKeyCreateReq struct {
+	// Name is the name of the key, it is the comment of the key if empty.
+	Name string `json:"name"`
+
+	// Key is the public key in the authorized_keys format, like "ssh-ed25519 AAAA... alice@host".
+	Key string `json:"key" binding:"required"`
+}
+
+// @summary     SSHKeyCreate
+// @description Add a ssh key of the current user, to use git over ssh with the same
+// @description permissions as the user.
+// @tags        account
+// @accept      json
+// @produce     json
+// @param       sshKeyCreateReq body     sshKeyCreateReq true "The name and the public key"
+// @success     200             {object} any{key=model.SSHKey}
+// @failure     400             {object} any{error=string}
+// @failure     403             {object} any{error=string}
+// @failure     409             {object} any{error=string}
+// @failure     500             {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /key [post]
+func HandleSSHKeyCreate(c *gin.Context) {
+	userID, _ := c.MustGet("_user").(uuid.UUID)
+
+	var params sshKeyCreateReq
+
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !requireSession(c) {
+		return
+	}
+
+	key, err := model.CreateSSHKey(db.PDB, userID, params.Name, params.Key)
+	if errors.Is(err, model.ErrInvalidSSHKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ssh key"})
+		return
+	}
+	if errors.Is(err, model.ErrSSHKeyExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "ssh key already exists"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to create ssh key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create ssh key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"key": key})
+}
+
+// @summary     SSHKeyDelete
+// @description Remove a ssh key of the current user.
+// @tags        account
+// @produce     json
+// @param       id  path     string true "SSH key ID"
+// @success     200 {object} any
+// @failure     400 {object} any{error=string}
+// @failure     403 {object} any{error=string}
+// @failure     404 {object} any{error=string}
+// @failure     500 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /key/{id} [delete]
+func HandleSSHKeyDelete(c *gin.Context) {
+	userID, _ := c.MustGet("_user").(uuid.UUID)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if !requireSession(c) {
+		return
+	}
+
+	err = model.DeleteSSHKey(db.PDB, userID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ssh key not found"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to delete ssh key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete ssh key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"rindag/model"
+	"rindag/service/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// accessToken returns the personal access token authenticating the request,
+// or nil if it is authenticated otherwise.
+func accessToken(c *gin.Context) *model.AccessToken {
+	token, ok := c.Get("_token")
+	if !ok {
+		return nil
+	}
+	return token.(*model.AccessToken)
+}
+
+// requireSession checks the request is not authenticated by a personal access token,
+// so a leaked token can not manage the tokens and ssh keys.
+//
+// If it fails, an error response is written.
+func requireSession(c *gin.Context) bool {
+	if accessToken(c) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access tokens can not manage credentials"})
+		return false
+	}
+	return true
+}
+
+// @summary     TokenList
+// @description List the personal access tokens of the current user.
+// @tags        account
+// @produce     json
+// @success     200 {object} any{tokens=[]model.AccessToken}
+// @failure     403 {object} any{error=string}
+// @failure     500 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /token [get]
+func HandleTokenList(c *gin.Context) {
+	userID, _ := c.MustGet("_user").(uuid.UUID)
+
+	if !requireSession(c) {
+		return
+	}
+
+	tokens, err := model.ListAccessTokens(db.PDB, userID)
+	if err != nil {
+		log.WithError(err).Error("failed to list access tokens")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list access tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+type tokenCreateReq struct {
+	Name   string             `json:"name" binding:"required"`
+	Scopes []model.TokenScope `json:"scopes" binding:"required"`
+
+	// ExpiresAt is the expiration time of the token, it never expires if it is not set.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// @summary     TokenCreate
+// @description Create a personal access token of the current user.
+// @description The token is only returned once, use it as "X-API-KEY" with the "api" scope,
+// @description or as the git password with the "read_repository" or "write_repository" scope.
+// @tags        account
+// @accept      json
+// @produce     json
+// @param       tokenCreateReq body     tokenCreateReq true "The name, scopes and expiration"
+// @success     200            {object} any{token=string,info=model.AccessToken}
+// @failure     400            {object} any{error=string}
+// @failure     403            {object} any{error=string}
+// @failure     500            {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /token [post]
+func HandleTokenCreate(c *gin.Context) {
+	userID, _ := c.MustGet("_user").(uuid.UUID)
+
+	var params tokenCreateReq
+
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(params.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scopes are required"})
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !scope.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scope: " + string(scope)})
+			return
+		}
+	}
+	if params.ExpiresAt != nil && params.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expiration"})
+		return
+	}
+
+	if !requireSession(c) {
+		return
+	}
+
+	token, info, err := model.CreateAccessToken(
+		db.PDB, userID, params.Name, params.Scopes, params.ExpiresAt)
+	if err != nil {
+		log.WithError(err).Error("failed to create access token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create access token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "info": info})
+}
+
+// @summary     TokenDelete
+// @description Revoke a personal access token of the current user.
+// @tags        account
+// @produce     json
+// @param       id  path     string true "Token ID"
+// @success     200 {object} any
+// @failure     400 {object} any{error=string}
+// @failure     403 {object} any{error=string}
+// @failure     404 {object} any{error=string}
+// @failure     500 {object} any{error=string}
+// @security    ApiKeyAuth
+// @router      /token/{id} [delete]
+func HandleTokenDelete(c *gin.Context) {
+	userID, _ := c.MustGet("_user").(uuid.UUID)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if !requireSession(c) {
+		return
+	}
+
+	err = model.DeleteAccessToken(db.PDB, userID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "access token not found"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to delete access token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete access token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
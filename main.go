@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,6 +14,8 @@ import (
 	"rindag/handler"
 	"rindag/middleware"
 	"rindag/service/etc"
+	"rindag/service/git"
+	"rindag/service/metrics"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
@@ -37,6 +41,7 @@ func setupRouter() *gin.Engine {
 	r.Use(gin.Recovery())
 
 	r.GET("/ping", handler.HandlePing)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
 	r.POST("/login", handler.HandleLogin)
 
 	git := r.Group("/git")
@@ -61,12 +66,54 @@ func setupRouter() *gin.Engine {
 			judge.DELETE("/file/:judge_id/:file_id", handler.HandleJudgeFileDelete)
 		}
 
+		token := authorized.Group("/token")
+		{
+			token.GET("/", handler.HandleTokenList)
+			token.POST("/", handler.HandleTokenCreate)
+			token.DELETE("/:id", handler.HandleTokenDelete)
+		}
+
+		key := authorized.Group("/key")
+		{
+			key.GET("/", handler.HandleSSHKeyList)
+			key.POST("/", handler.HandleSSHKeyCreate)
+			key.DELETE("/:id", handler.HandleSSHKeyDelete)
+		}
+
+		job := authorized.Group("/job")
+		{
+			job.GET("/", handler.HandleJobList)
+			job.DELETE("/:id", handler.HandleJobCancel)
+		}
+
 		problem := authorized.Group("/problem")
 		{
 			problem.GET("/", handler.HandleProblemList)
 			problem.POST("/", handler.HandleProblemAdd)
 			problem.GET("/:id/config", handler.HandleProblemConfigGet)
+			problem.GET("/:id/tree", handler.HandleProblemTree)
+			problem.GET("/:id/blob", handler.HandleProblemBlob)
+			problem.GET("/:id/log", handler.HandleProblemLog)
+			problem.GET("/:id/diff", handler.HandleProblemDiff)
+			problem.GET("/:id/branches", handler.HandleProblemBranchList)
+			problem.POST("/:id/branches", handler.HandleProblemBranchCreate)
+			problem.DELETE("/:id/branches/*name", handler.HandleProblemBranchDelete)
+			problem.GET("/:id/tags", handler.HandleProblemTagList)
+			problem.POST("/:id/tags", handler.HandleProblemTagCreate)
+			problem.DELETE("/:id/tags/*name", handler.HandleProblemTagDelete)
+			problem.POST("/:id/publish", handler.HandleProblemPublish)
+			problem.GET("/:id/members", handler.HandleProblemMemberList)
+			problem.PUT("/:id/members", handler.HandleProblemMemberSet)
+			problem.DELETE("/:id/members/:user", handler.HandleProblemMemberDelete)
+			problem.GET("/:id/protections", handler.HandleProblemProtectionList)
+			problem.PUT("/:id/protections", handler.HandleProblemProtectionSet)
+			problem.DELETE("/:id/protections/*branch", handler.HandleProblemProtectionDelete)
+			problem.POST("/:id/commit", handler.HandleProblemCommit)
 			problem.POST("/:id/build", handler.HandleProblemBuild)
+			problem.GET("/:id/builds", handler.HandleProblemBuildList)
+			problem.GET("/:id/builds/:rev", handler.HandleProblemBuildGet)
+			problem.POST("/:id/submit", handler.HandleProblemSubmit)
+			problem.GET("/:id/inputs", handler.HandleProblemInputs)
 			problem.GET("/:id/package", handler.HandleProblemPackage)
 		}
 	}
@@ -90,12 +137,35 @@ func main() {
 		}
 	}()
 
+	// Serve git over ssh if it is enabled.
+	var sshListener net.Listener
+	if etc.Config.Git.SSH.Addr != "" {
+		hostKey, err := git.LoadHostKey(etc.Config.Git.SSH.HostKey)
+		if err != nil {
+			log.WithError(err).Fatal("Error loading ssh host key")
+		}
+		sshListener, err = net.Listen("tcp", etc.Config.Git.SSH.Addr)
+		if err != nil {
+			log.WithError(err).Fatal("Error listening ssh")
+		}
+		go func() {
+			err := git.ServeSSH(sshListener, handler.NewSSHServerConfig(hostKey), handler.HandleSSHGit)
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				log.WithError(err).Fatal("Error serving ssh")
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shut down the server with a timeout of 10 seconds.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Info("Shutting down server...")
 
+	if sshListener != nil {
+		sshListener.Close()
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
@@ -10,6 +10,9 @@ import (
 )
 
 // GitAuthMiddleware is a middleware that validates the user and password for git.
+//
+// Instead of the password, a personal access token with model.ScopeReadRepository can be used,
+// and the user name is ignored. Its record is set as "_token".
 func GitAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -25,6 +28,18 @@ func GitAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if model.IsAccessToken(password) {
+			token, err := model.AuthenticateAccessToken(db.PDB, password)
+			if err != nil || !token.HasScope(model.ScopeReadRepository) {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+			c.Set("_user", token.User)
+			c.Set("_token", token)
+			c.Next()
+			return
+		}
+
 		user, err := model.GetUser(db.PDB, account)
 		if err != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
@@ -3,16 +3,39 @@ package middleware
 import (
 	"net/http"
 
+	"rindag/model"
+	"rindag/service/db"
 	"rindag/utils"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
 
-// JWTMiddleware is a middleware that validates a JWT token.
+// JWTMiddleware is a middleware that validates a JWT token,
+// or a personal access token with model.ScopeAPI.
+//
+// For a personal access token, its record is set as "_token".
 func JWTMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token, err := utils.ParseToken(c.GetHeader("X-API-KEY"))
+		key := c.GetHeader("X-API-KEY")
+		if model.IsAccessToken(key) {
+			token, err := model.AuthenticateAccessToken(db.PDB, key)
+			if err != nil {
+				log.WithError(err).Error("Error authenticating access token")
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+			if !token.HasScope(model.ScopeAPI) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Set("_user", token.User)
+			c.Set("_token", token)
+			c.Next()
+			return
+		}
+
+		token, err := utils.ParseToken(key)
 		if err != nil {
 			log.WithError(err).Error("Error parsing token")
 			c.AbortWithStatus(http.StatusUnauthorized)
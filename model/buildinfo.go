@@ -9,6 +9,26 @@ import (
 	"gorm.io/gorm"
 )
 
+// BuildStatus is the status of a build.
+type BuildStatus string
+
+const (
+	// BuildStatusPending is the status of a build waiting to start.
+	BuildStatusPending BuildStatus = "pending"
+
+	// BuildStatusRunning is the status of a running build.
+	BuildStatusRunning BuildStatus = "running"
+
+	// BuildStatusSucceeded is the status of a build whose info is OK.
+	BuildStatusSucceeded BuildStatus = "succeeded"
+
+	// BuildStatusFailed is the status of a build whose info is not OK.
+	BuildStatusFailed BuildStatus = "failed"
+
+	// BuildStatusCancelled is the status of a cancelled build.
+	BuildStatusCancelled BuildStatus = "cancelled"
+)
+
 // BuildInfo is the build information of the problem.
 type BuildInfo struct {
 	// Problem is the ID of the problem.
@@ -20,6 +40,11 @@ type BuildInfo struct {
 	// BuildTime is the time of the build.
 	BuildTime time.Time `gorm:"not null"`
 
+	// Status is the status of the build.
+	//
+	// It is empty for the builds recorded before statuses.
+	Status BuildStatus
+
 	// Info is the build information.
 	Info problem.BuildInfo `gorm:"not null"`
 }
@@ -31,20 +56,57 @@ func GetBuildInfo(db *gorm.DB, problem *Problem, rev [20]byte) (*BuildInfo, erro
 	return &buildInfo, err
 }
 
+// ListBuildInfos returns the build information of the problem from the latest build,
+// skipping offset builds and at most limit builds, with the total number of its builds.
+func ListBuildInfos(
+	db *gorm.DB, problem *Problem, offset int, limit int,
+) ([]BuildInfo, int64, error) {
+	var (
+		buildInfos []BuildInfo
+		total      int64
+	)
+	if err := db.Model(&BuildInfo{}).Where("problem = ?", problem.ID).
+		Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := db.Where("problem = ?", problem.ID).Order("build_time DESC").
+		Offset(offset).Limit(limit).Find(&buildInfos).Error
+	return buildInfos, total, err
+}
+
 // UpdateBuildInfo creates a new build information.
+//
+// The status of the build is BuildStatusSucceeded if the info is OK, otherwise BuildStatusFailed.
+// The last build of the problem is not changed, see SetLastBuild.
 func UpdateBuildInfo(
 	db *gorm.DB, problem *Problem, rev [20]byte, info problem.BuildInfo,
 ) (*BuildInfo, error) {
-	problem.LastBuildRev = rev[:]
-	if err := db.Save(problem).Error; err != nil {
-		return nil, err
+	status := BuildStatusSucceeded
+	if !info.OK {
+		status = BuildStatusFailed
 	}
 	buildInfo := &BuildInfo{
 		Problem:   problem.ID,
 		Rev:       rev[:],
 		BuildTime: time.Now(),
+		Status:    status,
 		Info:      info,
 	}
 	err := db.Save(buildInfo).Error
 	return buildInfo, err
 }
+
+// SetBuildStatus records the status of the build of the problem at rev before its info is ready,
+// like a pending build.
+func SetBuildStatus(
+	db *gorm.DB, problem *Problem, rev [20]byte, status BuildStatus,
+) (*BuildInfo, error) {
+	buildInfo := &BuildInfo{
+		Problem:   problem.ID,
+		Rev:       rev[:],
+		BuildTime: time.Now(),
+		Status:    status,
+	}
+	err := db.Save(buildInfo).Error
+	return buildInfo, err
+}
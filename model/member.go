@@ -0,0 +1,144 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Role is the role of a user in a problem.
+type Role string
+
+const (
+	// RoleOwner can manage the members and the branch protections, and publish the problem.
+	RoleOwner Role = "owner"
+
+	// RoleEditor can push to the problem.
+	RoleEditor Role = "editor"
+
+	// RoleViewer can only clone the problem.
+	RoleViewer Role = "viewer"
+)
+
+// roleRanks are the ranks of the roles, a role includes the permissions of lower ranks.
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// Valid returns true if the role is a known role.
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Includes returns true if the role has all the permissions of the other role,
+// like RoleEditor includes RoleViewer.
+func (r Role) Includes(other Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[other]
+}
+
+// ProblemMember is the role of a user in a problem.
+type ProblemMember struct {
+	Problem uuid.UUID `gorm:"primary_key;type:uuid" json:"problem"`
+	User    uuid.UUID `gorm:"primary_key;type:uuid" json:"user"`
+	Role    Role      `gorm:"not null" json:"role"`
+}
+
+// ErrLastOwner is returned when the last owner of a problem is removed or demoted.
+var ErrLastOwner = errors.New("problem should have an owner")
+
+// GetRole returns the role of the user in the problem, or an empty role if the user is not a
+// member.
+func GetRole(db *gorm.DB, problem *Problem, user uuid.UUID) (Role, error) {
+	var member ProblemMember
+	err := db.Where("problem = ? AND \"user\" = ?", problem.ID, user).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return member.Role, err
+}
+
+// MigrateMembers migrates the members of problems.
+//
+// When the members are created, the problems created before roles get their owners,
+// see backfillOwners. It is done once in the transaction creating the members,
+// so the problems left without members later never get owners again.
+func MigrateMembers(db *gorm.DB) error {
+	if db.Migrator().HasTable(&ProblemMember{}) {
+		return db.AutoMigrate(&ProblemMember{})
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&ProblemMember{}); err != nil {
+			return err
+		}
+		return backfillOwners(tx)
+	})
+}
+
+// backfillOwners makes every user an owner of the problems without members,
+// which are created before roles, when everyone could manage them.
+// The owners can remove the other members later.
+func backfillOwners(db *gorm.DB) error {
+	return db.Exec(`INSERT INTO problem_members (problem, "user", role)
+SELECT problems.id, users.id, ? FROM problems CROSS JOIN users
+WHERE NOT EXISTS (SELECT 1 FROM problem_members WHERE problem_members.problem = problems.id)`,
+		RoleOwner).Error
+}
+
+// checkLastOwner returns ErrLastOwner if the user is the last owner of the problem.
+//
+// The owners are locked until the end of the transaction, so they can not be changed
+// concurrently.
+func checkLastOwner(tx *gorm.DB, problem *Problem, user uuid.UUID) error {
+	var owners []ProblemMember
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("problem = ? AND role = ?", problem.ID, RoleOwner).Find(&owners).Error; err != nil {
+		return err
+	}
+	if len(owners) == 1 && owners[0].User == user {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// ListMembers returns the members of the problem.
+func ListMembers(db *gorm.DB, problem *Problem) ([]ProblemMember, error) {
+	var members []ProblemMember
+	err := db.Where("problem = ?", problem.ID).Find(&members).Error
+	return members, err
+}
+
+// SetMember sets the role of the user in the problem.
+// Returns ErrLastOwner if the user is the last owner and the role is not RoleOwner.
+func SetMember(db *gorm.DB, problem *Problem, user uuid.UUID, role Role) (*ProblemMember, error) {
+	member := &ProblemMember{
+		Problem: problem.ID,
+		User:    user,
+		Role:    role,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if role != RoleOwner {
+			if err := checkLastOwner(tx, problem, user); err != nil {
+				return err
+			}
+		}
+		return tx.Save(member).Error
+	})
+	return member, err
+}
+
+// DeleteMember removes the user from the members of the problem.
+// Returns ErrLastOwner if the user is the last owner.
+func DeleteMember(db *gorm.DB, problem *Problem, user uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := checkLastOwner(tx, problem, user); err != nil {
+			return err
+		}
+		return tx.Where("problem = ? AND \"user\" = ?", problem.ID, user).
+			Delete(&ProblemMember{}).Error
+	})
+}
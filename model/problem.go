@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 	"gorm.io/gorm"
@@ -12,6 +14,61 @@ type Problem struct {
 	Name         string         `gorm:"not null" json:"name"`
 	Tags         pq.StringArray `gorm:"not null;type:text[]" json:"tags"`
 	LastBuildRev []byte         `gorm:"not null;default:decode('00000000000000000000','hex')" json:"last_build_rev"`
+
+	// LastBuildTime is the start time of the build of LastBuildRev, see SetLastBuild.
+	LastBuildTime *time.Time `json:"last_build_time"`
+
+	// ReleaseTag is the published tag, see PublishRelease.
+	ReleaseTag string `json:"release_tag"`
+
+	// ReleaseRev is the commit of the published tag when it is published.
+	ReleaseRev []byte `json:"release_rev"`
+}
+
+// JudgeRev returns the revision used by packaging and judging,
+// which is the release if the problem is published, otherwise the last build.
+func (problem *Problem) JudgeRev() [20]byte {
+	var rev [20]byte
+	if len(problem.ReleaseRev) == len(rev) {
+		copy(rev[:], problem.ReleaseRev)
+	} else {
+		copy(rev[:], problem.LastBuildRev)
+	}
+	return rev
+}
+
+// PublishRelease pins the commit of the tag as the release of the problem.
+//
+// The release does not change when the tag is moved, until it is published again.
+func PublishRelease(db *gorm.DB, problem *Problem, tag string, rev [20]byte) error {
+	if err := db.Model(&Problem{}).Where("id = ?", problem.ID).Updates(map[string]interface{}{
+		"release_tag": tag,
+		"release_rev": rev[:],
+	}).Error; err != nil {
+		return err
+	}
+	problem.ReleaseTag = tag
+	problem.ReleaseRev = rev[:]
+	return nil
+}
+
+// SetLastBuild sets rev as the last build of the problem, which is judged if it is not published.
+// The build should be successful and its tests should be saved.
+//
+// A build started before the last build does not replace it, and false is returned.
+func SetLastBuild(db *gorm.DB, problem *Problem, rev [20]byte, startTime time.Time) (bool, error) {
+	result := db.Model(&Problem{}).
+		Where("id = ? AND (last_build_time IS NULL OR last_build_time < ?)", problem.ID, startTime).
+		Updates(map[string]interface{}{
+			"last_build_rev":  rev[:],
+			"last_build_time": startTime,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	problem.LastBuildRev = rev[:]
+	problem.LastBuildTime = &startTime
+	return true, nil
 }
 
 // GetProblemIDsList returns a list of IDs of all problem.
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// BranchProtection is the rules of a protected branch of a problem.
+//
+// A protected branch can not be deleted.
+type BranchProtection struct {
+	Problem uuid.UUID `gorm:"primary_key;type:uuid" json:"-"`
+
+	// Branch is the short name of the branch, like "main".
+	Branch string `gorm:"primary_key" json:"branch"`
+
+	// DenyForcePush rejects the updates of the branch which are not fast-forward.
+	DenyForcePush bool `gorm:"not null" json:"deny_force_push"`
+
+	// RequireBuild rejects the updates of the branch to a commit not built successfully.
+	RequireBuild bool `gorm:"not null" json:"require_build"`
+}
+
+// GetBranchProtection returns the protection of the branch of the problem,
+// or nil if the branch is not protected.
+func GetBranchProtection(db *gorm.DB, problem *Problem, branch string) (*BranchProtection, error) {
+	var protection BranchProtection
+	err := db.Where("problem = ? AND branch = ?", problem.ID, branch).First(&protection).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &protection, nil
+}
+
+// ListBranchProtections returns the protections of the branches of the problem.
+func ListBranchProtections(db *gorm.DB, problem *Problem) ([]BranchProtection, error) {
+	var protections []BranchProtection
+	err := db.Where("problem = ?", problem.ID).Find(&protections).Error
+	return protections, err
+}
+
+// SetBranchProtection protects the branch of the problem with the rules.
+func SetBranchProtection(db *gorm.DB, protection *BranchProtection) error {
+	return db.Save(protection).Error
+}
+
+// DeleteBranchProtection unprotects the branch of the problem.
+func DeleteBranchProtection(db *gorm.DB, problem *Problem, branch string) error {
+	return db.Where("problem = ? AND branch = ?", problem.ID, branch).
+		Delete(&BranchProtection{}).Error
+}
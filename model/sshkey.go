@@ -0,0 +1,108 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidSSHKey is returned when adding a public key which can not be parsed.
+	ErrInvalidSSHKey = errors.New("invalid ssh key")
+
+	// ErrSSHKeyExists is returned when adding a public key which is already added by any user.
+	ErrSSHKeyExists = errors.New("ssh key already exists")
+)
+
+// SSHKey is a public key of a user, to use git over ssh.
+type SSHKey struct {
+	ID   uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	User uuid.UUID `gorm:"not null;type:uuid;index" json:"user"`
+	Name string    `gorm:"not null" json:"name"`
+
+	// Fingerprint is the SHA-256 fingerprint of the key, like "SHA256:...".
+	Fingerprint string `gorm:"not null;uniqueIndex" json:"fingerprint"`
+
+	// PublicKey is the key in the authorized_keys format, without the comment.
+	PublicKey string `gorm:"not null" json:"public_key"`
+
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CreateSSHKey adds a public key of the user, in the authorized_keys format like
+// "ssh-ed25519 AAAA... alice@host".
+//
+// If the name is empty, the comment of the key is used.
+func CreateSSHKey(db *gorm.DB, user uuid.UUID, name string, authorizedKey string) (*SSHKey, error) {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return nil, ErrInvalidSSHKey
+	}
+	if name == "" {
+		name = comment
+	}
+
+	record := &SSHKey{
+		User:        user,
+		Name:        name,
+		Fingerprint: ssh.FingerprintSHA256(key),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		CreatedAt:   time.Now(),
+	}
+
+	var count int64
+	if err := db.Model(&SSHKey{}).
+		Where("fingerprint = ?", record.Fingerprint).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrSSHKeyExists
+	}
+
+	if err := db.Create(record).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// ListSSHKeys returns the public keys of the user.
+func ListSSHKeys(db *gorm.DB, user uuid.UUID) ([]SSHKey, error) {
+	var keys []SSHKey
+	err := db.Where("\"user\" = ?", user).Order("created_at").Find(&keys).Error
+	return keys, err
+}
+
+// DeleteSSHKey removes the public key of the user.
+//
+// Returns gorm.ErrRecordNotFound if the user has no such key.
+func DeleteSSHKey(db *gorm.DB, user uuid.UUID, id uuid.UUID) error {
+	result := db.Where("id = ? AND \"user\" = ?", id, user).Delete(&SSHKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// AuthenticateSSHKey returns the record of the public key offered by a ssh client,
+// and records the time it is used.
+func AuthenticateSSHKey(db *gorm.DB, key ssh.PublicKey) (*SSHKey, error) {
+	var record SSHKey
+	if err := db.Where("fingerprint = ?", ssh.FingerprintSHA256(key)).
+		First(&record).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	record.LastUsedAt = &now
+	err := db.Model(&record).Update("last_used_at", now).Error
+	return &record, err
+}
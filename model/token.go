@@ -0,0 +1,153 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// AccessTokenPrefix is the prefix of personal access tokens, to tell them from passwords and JWTs.
+const AccessTokenPrefix = "rdg_"
+
+// TokenScope is a scope of a personal access token.
+type TokenScope string
+
+const (
+	// ScopeAPI allows the token to use the API as the user.
+	ScopeAPI TokenScope = "api"
+
+	// ScopeReadRepository allows the token to clone and fetch the repositories by git.
+	ScopeReadRepository TokenScope = "read_repository"
+
+	// ScopeWriteRepository allows the token to clone, fetch and push the repositories by git.
+	ScopeWriteRepository TokenScope = "write_repository"
+)
+
+// Valid returns true if the scope is a known scope.
+func (s TokenScope) Valid() bool {
+	switch s {
+	case ScopeAPI, ScopeReadRepository, ScopeWriteRepository:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrAccessTokenExpired is returned when authenticating with an expired access token.
+var ErrAccessTokenExpired = errors.New("access token is expired")
+
+// AccessToken is a personal access token of a user.
+//
+// Only the SHA-256 hash of the token is stored, the token is shown once when it is created.
+type AccessToken struct {
+	ID     uuid.UUID      `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	User   uuid.UUID      `gorm:"not null;type:uuid;index" json:"user"`
+	Name   string         `gorm:"not null" json:"name"`
+	Hash   []byte         `gorm:"not null;uniqueIndex" json:"-"`
+	Scopes pq.StringArray `gorm:"not null;type:text[]" json:"scopes"`
+
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+
+	// ExpiresAt is the expiration time of the token, it never expires if nil.
+	ExpiresAt *time.Time `json:"expires_at"`
+
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// IsAccessToken returns true if the credential looks like a personal access token.
+func IsAccessToken(credential string) bool {
+	return strings.HasPrefix(credential, AccessTokenPrefix)
+}
+
+// hashAccessToken returns the hash of the token stored in database.
+func hashAccessToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// HasScope returns true if the token has the scope.
+//
+// ScopeWriteRepository includes ScopeReadRepository.
+func (t *AccessToken) HasScope(scope TokenScope) bool {
+	for _, s := range t.Scopes {
+		if TokenScope(s) == scope ||
+			(scope == ScopeReadRepository && TokenScope(s) == ScopeWriteRepository) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAccessToken creates a personal access token of the user.
+// Returns the token, which can not be got again, and its record.
+func CreateAccessToken(
+	db *gorm.DB, user uuid.UUID, name string, scopes []TokenScope, expiresAt *time.Time,
+) (string, *AccessToken, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	token := AccessTokenPrefix + hex.EncodeToString(secret)
+
+	record := &AccessToken{
+		User:      user,
+		Name:      name,
+		Hash:      hashAccessToken(token),
+		Scopes:    pq.StringArray{},
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	for _, scope := range scopes {
+		record.Scopes = append(record.Scopes, string(scope))
+	}
+	if err := db.Create(record).Error; err != nil {
+		return "", nil, err
+	}
+	return token, record, nil
+}
+
+// ListAccessTokens returns the personal access tokens of the user.
+func ListAccessTokens(db *gorm.DB, user uuid.UUID) ([]AccessToken, error) {
+	var tokens []AccessToken
+	err := db.Where("\"user\" = ?", user).Order("created_at").Find(&tokens).Error
+	return tokens, err
+}
+
+// DeleteAccessToken revokes the personal access token of the user.
+//
+// Returns gorm.ErrRecordNotFound if the user has no such token.
+func DeleteAccessToken(db *gorm.DB, user uuid.UUID, id uuid.UUID) error {
+	result := db.Where("id = ? AND \"user\" = ?", id, user).Delete(&AccessToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// AuthenticateAccessToken returns the record of the personal access token,
+// and records the time it is used.
+func AuthenticateAccessToken(db *gorm.DB, token string) (*AccessToken, error) {
+	var record AccessToken
+	if err := db.Where("hash = ?", hashAccessToken(token)).First(&record).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if record.ExpiresAt != nil && record.ExpiresAt.Before(now) {
+		return nil, ErrAccessTokenExpired
+	}
+
+	record.LastUsedAt = &now
+	err := db.Model(&record).Update("last_used_at", now).Error
+	return &record, err
+}
@@ -55,6 +55,18 @@ func setupPostgres() {
 	if err := PDB.AutoMigrate(&model.BuildInfo{}); err != nil {
 		log.WithError(err).Fatal("Postgres migration failed")
 	}
+	if err := model.MigrateMembers(PDB); err != nil {
+		log.WithError(err).Fatal("Postgres migration failed")
+	}
+	if err := PDB.AutoMigrate(&model.BranchProtection{}); err != nil {
+		log.WithError(err).Fatal("Postgres migration failed")
+	}
+	if err := PDB.AutoMigrate(&model.AccessToken{}); err != nil {
+		log.WithError(err).Fatal("Postgres migration failed")
+	}
+	if err := PDB.AutoMigrate(&model.SSHKey{}); err != nil {
+		log.WithError(err).Fatal("Postgres migration failed")
+	}
 	log.Info("Postgres connected")
 }
 
@@ -49,11 +49,23 @@ type Configuration struct {
 		// RepoDir is the path to the git repositories.
 		// Like "/var/lib/rindag/git".
 		RepoDir string `mapstructure:"repo_dir"`
+
+		// SSH is the embedded ssh server for git, authenticated by the ssh keys of users.
+		SSH struct {
+			// Addr is the address to listen on, like ":2222". It is disabled if empty.
+			Addr string `mapstructure:"addr"`
+
+			// HostKey is the path to the private host key, which is generated if it does not exist.
+			HostKey string `mapstructure:"host_key"`
+		} `mapstructure:"ssh"`
 	} `mapstructure:"git"`
 
 	Judges map[string]struct {
 		Host  string `mapstructure:"host"`
 		Token string `mapstructure:"token"`
+
+		// Parallelism is the maximum number of tasks executed by the judge at the same time.
+		Parallelism int `mapstructure:"parallelism"`
 	} `mapstructure:"judges"`
 
 	Compile struct {
@@ -63,6 +75,27 @@ type Configuration struct {
 		StderrLimit int64    `mapstructure:"stderr_limit"`
 	} `mapstructure:"compile"`
 
+	Testlib struct {
+		// Dir is an optional directory of testlib versions overriding the embedded versions,
+		// the version "<v>" is "<Dir>/<v>/testlib.h".
+		Dir string `mapstructure:"dir"`
+	} `mapstructure:"testlib"`
+
+	// Languages are the languages of submissions by their names, like "cpp".
+	Languages map[string]struct {
+		// Source is the name of the source file.
+		Source string `mapstructure:"source"`
+
+		// Binary is the name of the file to run, which is copied out from the compile task.
+		Binary string `mapstructure:"binary"`
+
+		// CompileCmd is the command to compile the source.
+		CompileCmd []string `mapstructure:"compile_cmd"`
+
+		// RunCmd is the command to run the binary.
+		RunCmd []string `mapstructure:"run_cmd"`
+	} `mapstructure:"languages"`
+
 	Validator struct {
 		Compile struct {
 			Args []string `mapstructure:"args"`
@@ -87,6 +120,18 @@ type Configuration struct {
 		} `mapstructure:"run"`
 	} `mapstructure:"checker"`
 
+	Manager struct {
+		Compile struct {
+			Args []string `mapstructure:"args"`
+		} `mapstructure:"compile"`
+
+		Run struct {
+			TimeLimit   uint64 `mapstructure:"time_limit"`
+			MemoryLimit uint64 `mapstructure:"memory_limit"`
+			StderrLimit int64  `mapstructure:"stderr_limit"`
+		} `mapstructure:"run"`
+	} `mapstructure:"manager"`
+
 	Generator struct {
 		Compile struct {
 			Args []string `mapstructure:"args"`
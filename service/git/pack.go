@@ -0,0 +1,61 @@
+package git
+
+import (
+	"io"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// packStorage is a storage of the objects of a pack in memory,
+// which falls back to the objects of a repository.
+type packStorage struct {
+	*memory.Storage
+
+	base storer.EncodedObjectStorer
+}
+
+// EncodedObject gets an object by its type and hash from the pack or the repository.
+func (s *packStorage) EncodedObject(
+	t plumbing.ObjectType, h plumbing.Hash,
+) (plumbing.EncodedObject, error) {
+	if obj, err := s.Storage.EncodedObject(t, h); err == nil {
+		return obj, nil
+	}
+	return s.base.EncodedObject(t, h)
+}
+
+// HasEncodedObject returns nil if the object is in the pack or the repository.
+func (s *packStorage) HasEncodedObject(h plumbing.Hash) error {
+	if err := s.Storage.HasEncodedObject(h); err == nil {
+		return nil
+	}
+	return s.base.HasEncodedObject(h)
+}
+
+// EncodedObjectSize returns the size of the object in the pack or the repository.
+func (s *packStorage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	if size, err := s.Storage.EncodedObjectSize(h); err == nil {
+		return size, nil
+	}
+	return s.base.EncodedObjectSize(h)
+}
+
+// OpenWithPack returns a repository with the objects of the pack and the repository,
+// like the quarantine of a push before it is received.
+//
+// The repository is not modified.
+// The returned repository has no references except HEAD, its objects should be read by hashes.
+func OpenWithPack(repo *gogit.Repository, pack io.Reader) (*gogit.Repository, error) {
+	s := &packStorage{Storage: memory.NewStorage(), base: repo.Storer}
+	if err := packfile.UpdateObjectStorage(s, pack); err != nil {
+		return nil, err
+	}
+	if err := s.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, MainBranch)); err != nil {
+		return nil, err
+	}
+	return gogit.Open(s, nil)
+}
@@ -0,0 +1,165 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// MainBranch is the branch whose pushes are built automatically.
+const MainBranch = plumbing.ReferenceName("refs/heads/main")
+
+// ErrInvalidPktLine is returned when a receive-pack request has an invalid pkt-line.
+var ErrInvalidPktLine = errors.New("invalid pkt-line")
+
+// ReceiveCommand is a command to update a reference in a push.
+type ReceiveCommand struct {
+	Old plumbing.Hash
+	New plumbing.Hash
+	Ref plumbing.ReferenceName
+}
+
+// IsDelete returns true if the command deletes the reference.
+func (c *ReceiveCommand) IsDelete() bool {
+	return c.New.IsZero()
+}
+
+// ReceiveRequest is the commands of a receive-pack request, which are followed by a pack.
+type ReceiveRequest struct {
+	Commands []ReceiveCommand
+
+	// Capabilities are the capabilities requested by the client, like "report-status".
+	Capabilities []string
+
+	// header is the raw commands read from the request.
+	header []byte
+}
+
+// ReadReceiveRequest reads the commands at the beginning of a receive-pack request,
+// the rest of r is the pack.
+func ReadReceiveRequest(r io.Reader) (*ReceiveRequest, error) {
+	buf := &bytes.Buffer{}
+	tee := io.TeeReader(r, buf)
+
+	req := &ReceiveRequest{Commands: []ReceiveCommand{}, Capabilities: []string{}}
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(tee, size[:]); err != nil {
+			if errors.Is(err, io.EOF) && len(req.Commands) == 0 {
+				// An empty request, nothing to update.
+				break
+			}
+			return nil, err
+		}
+		n, err := strconv.ParseUint(string(size[:]), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPktLine, err)
+		}
+		if n == 0 {
+			// Flush packet, the end of the commands.
+			break
+		}
+		if n < 4 {
+			return nil, fmt.Errorf("%w: length %d", ErrInvalidPktLine, n)
+		}
+		line := make([]byte, n-4)
+		if _, err := io.ReadFull(tee, line); err != nil {
+			return nil, err
+		}
+
+		// The capabilities follow the first command after a NUL.
+		if i := bytes.IndexByte(line, 0); i >= 0 {
+			req.Capabilities = strings.Fields(string(line[i+1:]))
+			line = line[:i]
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 2 && fields[0] == "shallow" {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPktLine, line)
+		}
+		req.Commands = append(req.Commands, ReceiveCommand{
+			Old: plumbing.NewHash(fields[0]),
+			New: plumbing.NewHash(fields[1]),
+			Ref: plumbing.ReferenceName(fields[2]),
+		})
+	}
+
+	req.header = buf.Bytes()
+	return req, nil
+}
+
+// HasCapability returns true if the capability is requested by the client.
+func (req *ReceiveRequest) HasCapability(name string) bool {
+	for _, c := range req.Capabilities {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Request returns a reader of the whole request with the pack,
+// which can be passed to "git receive-pack".
+func (req *ReceiveRequest) Request(pack io.Reader) io.Reader {
+	return io.MultiReader(bytes.NewReader(req.header), pack)
+}
+
+// pktLine encodes the data to a pkt-line.
+func pktLine(data string) []byte {
+	return []byte(fmt.Sprintf("%04x%s", len(data)+4, data))
+}
+
+// WriteReceiveRejection writes the response of a receive-pack request, which rejects all the
+// commands with the reason, like a declining pre-receive hook.
+//
+// The messages are shown in the git client by side-band, if the client supports it.
+func WriteReceiveRejection(
+	w io.Writer, req *ReceiveRequest, reason string, messages []string,
+) error {
+	report := &bytes.Buffer{}
+	if req.HasCapability("report-status") || req.HasCapability("report-status-v2") {
+		report.Write(pktLine("unpack ok\n"))
+		for _, command := range req.Commands {
+			report.Write(pktLine(fmt.Sprintf("ng %s %s\n", command.Ref, reason)))
+		}
+		report.WriteString("0000")
+	}
+
+	// The maximum length of the data of a side-band packet, with the band number.
+	maxLen := 0
+	if req.HasCapability("side-band-64k") {
+		maxLen = 65520 - 5
+	} else if req.HasCapability("side-band") {
+		maxLen = 1000 - 5
+	}
+	if maxLen == 0 {
+		_, err := w.Write(report.Bytes())
+		return err
+	}
+
+	out := &bytes.Buffer{}
+	band := func(b byte, data string) {
+		for len(data) > 0 {
+			n := len(data)
+			if n > maxLen {
+				n = maxLen
+			}
+			out.Write(pktLine(string(b) + data[:n]))
+			data = data[n:]
+		}
+	}
+	for _, msg := range messages {
+		band(2, msg+"\n")
+	}
+	band(1, report.String())
+	out.WriteString("0000")
+	_, err := w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,142 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// pkt encodes the data to a pkt-line.
+func pkt(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+// TestReadReceiveRequest tests that the commands are read and the request is kept intact.
+func TestReadReceiveRequest(t *testing.T) {
+	old := strings.Repeat("0", 40)
+	hash := strings.Repeat("a", 40)
+	header := pkt(old+" "+hash+" refs/heads/main\x00report-status side-band-64k\n") +
+		pkt(hash+" "+old+" refs/heads/dev\n") +
+		"0000"
+	r := strings.NewReader(header + "PACK...")
+
+	req, err := ReadReceiveRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Commands) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(req.Commands))
+	}
+	if req.Commands[0].Ref != MainBranch || req.Commands[0].New != plumbing.NewHash(hash) ||
+		req.Commands[0].IsDelete() {
+		t.Errorf("unexpected first command: %+v", req.Commands[0])
+	}
+	if req.Commands[1].Ref != "refs/heads/dev" || !req.Commands[1].IsDelete() {
+		t.Errorf("unexpected second command: %+v", req.Commands[1])
+	}
+	if !req.HasCapability("side-band-64k") || req.HasCapability("side-band") {
+		t.Errorf("unexpected capabilities: %v", req.Capabilities)
+	}
+
+	content, err := io.ReadAll(req.Request(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != header+"PACK..." {
+		t.Errorf("request should be kept intact, got %q", content)
+	}
+
+	if _, err := ReadReceiveRequest(strings.NewReader("zzzz")); err == nil {
+		t.Error("invalid pkt-line should fail")
+	}
+}
+
+// TestWriteReceiveRejection tests the report and the side-band messages of a rejection.
+func TestWriteReceiveRejection(t *testing.T) {
+	req := &ReceiveRequest{
+		Commands:     []ReceiveCommand{{Ref: MainBranch}},
+		Capabilities: []string{"report-status", "side-band-64k"},
+	}
+	w := &bytes.Buffer{}
+	if err := WriteReceiveRejection(w, req, "declined", []string{"bad config"}); err != nil {
+		t.Fatal(err)
+	}
+
+	report := pkt("unpack ok\n") + pkt("ng refs/heads/main declined\n") + "0000"
+	expected := pkt("\x02bad config\n") + pkt("\x01"+report) + "0000"
+	if w.String() != expected {
+		t.Errorf("expected %q, got %q", expected, w.String())
+	}
+}
+
+// commit makes a commit of a file in a new repository in memory.
+func commit(t *testing.T, name string) (*gogit.Repository, plumbing.Hash) {
+	repo, err := gogit.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := w.Filesystem.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(name))
+	file.Close()
+	if _, err := w.Add(name); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := w.Commit(name, &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@rindag.local"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, hash
+}
+
+// TestOpenWithPack tests reading the objects of a pack together with a repository.
+func TestOpenWithPack(t *testing.T) {
+	base, baseHash := commit(t, "a.txt")
+	pushed, pushedHash := commit(t, "b.txt")
+
+	hashes := []plumbing.Hash{}
+	iter, err := pushed.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := iter.ForEach(func(obj plumbing.EncodedObject) error {
+		hashes = append(hashes, obj.Hash())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pack := &bytes.Buffer{}
+	if _, err := packfile.NewEncoder(pack, pushed.Storer, false).Encode(hashes, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := OpenWithPack(base, pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []plumbing.Hash{baseHash, pushedHash} {
+		if _, err := repo.CommitObject(hash); err != nil {
+			t.Errorf("commit %s should be found: %s", hash, err)
+		}
+	}
+	if _, err := base.CommitObject(pushedHash); err == nil {
+		t.Error("the base repository should not be modified")
+	}
+}
@@ -0,0 +1,206 @@
+package git
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrInvalidSSHCommand is returned when a ssh client executes a command other than git.
+var ErrInvalidSSHCommand = errors.New("invalid ssh command")
+
+// ParseSSHCommand parses the command executed by a git client over ssh,
+// like "git-upload-pack '/<repo>.git'".
+//
+// The service is "upload-pack" or "receive-pack", and the repo is the name without ".git".
+func ParseSSHCommand(cmd string) (service string, repo string, err error) {
+	fields := strings.SplitN(strings.TrimSpace(cmd), " ", 2)
+	if len(fields) != 2 {
+		return "", "", ErrInvalidSSHCommand
+	}
+
+	switch fields[0] {
+	case "git-upload-pack", "git-receive-pack":
+		service = strings.TrimPrefix(fields[0], "git-")
+	default:
+		return "", "", ErrInvalidSSHCommand
+	}
+
+	// The path is quoted by git, and may be absolute like "/<repo>.git".
+	repo = strings.Trim(strings.TrimSpace(fields[1]), "'")
+	repo = strings.TrimSuffix(strings.Trim(repo, "/"), ".git")
+	if repo == "" || strings.ContainsAny(repo, "/\\'\" ") {
+		return "", "", ErrInvalidSSHCommand
+	}
+	return service, repo, nil
+}
+
+// LoadHostKey returns the private host key of the ssh server in the file.
+//
+// If the file does not exist, an ed25519 key is generated and saved to it.
+func LoadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.WithField("path", path).Info("generating ssh host key")
+		data, err = generateHostKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+// generateHostKey generates an ed25519 key and saves it to the file in PEM.
+func generateHostKey(path string) ([]byte, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// SSHRequest is a git command executed by an authenticated ssh client.
+type SSHRequest struct {
+	// Permissions are returned by the authentication of ssh.ServerConfig, like the user.
+	Permissions *ssh.Permissions
+
+	// Service is "upload-pack" or "receive-pack".
+	Service string
+
+	// Repo is the name of the repo without ".git".
+	Repo string
+
+	// Env is the environment set by the client for git, like "GIT_PROTOCOL=version=2".
+	Env []string
+}
+
+// SSHHandler runs the git command of the request with the ssh channel as its stdin and stdout,
+// errors are written to the stderr of the channel.
+//
+// Returns the exit status of the command.
+type SSHHandler func(req *SSHRequest, ch ssh.Channel) uint32
+
+// ServeSSH accepts the ssh connections on the listener, and serves their git commands by handle.
+//
+// It blocks until the listener is closed, and returns the error of accepting.
+func ServeSSH(l net.Listener, config *ssh.ServerConfig, handle SSHHandler) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveSSHConn(conn, config, handle)
+	}
+}
+
+// serveSSHConn serves the sessions of a ssh connection.
+func serveSSHConn(conn net.Conn, config *ssh.ServerConfig, handle SSHHandler) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.WithError(err).WithField("addr", conn.RemoteAddr()).Debug("ssh handshake failed")
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			log.WithError(err).Warn("failed to accept ssh channel")
+			continue
+		}
+		go serveSSHSession(sconn.Permissions, ch, requests, handle)
+	}
+}
+
+// serveSSHSession serves a ssh session, which executes a git command.
+//
+// Shells and other commands are rejected with a message.
+func serveSSHSession(
+	perms *ssh.Permissions, ch ssh.Channel, requests <-chan *ssh.Request, handle SSHHandler,
+) {
+	defer ch.Close()
+
+	env := []string{}
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			// Only the git protocol version is passed to git.
+			var payload struct{ Name, Value string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err == nil &&
+				payload.Name == "GIT_PROTOCOL" {
+				env = append(env, payload.Name+"="+payload.Value)
+			}
+			req.Reply(true, nil)
+
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+
+			service, repo, err := ParseSSHCommand(payload.Command)
+			if err != nil {
+				fmt.Fprintf(ch.Stderr(), "rindag: only git is supported, got %q\n", payload.Command)
+				sendExitStatus(ch, 1)
+				return
+			}
+			status := handle(&SSHRequest{
+				Permissions: perms,
+				Service:     service,
+				Repo:        repo,
+				Env:         env,
+			}, ch)
+			sendExitStatus(ch, status)
+			return
+
+		case "shell":
+			req.Reply(true, nil)
+			fmt.Fprintln(ch.Stderr(), "rindag: authenticated, but shell access is not provided")
+			sendExitStatus(ch, 1)
+			return
+
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// sendExitStatus sends the exit status of the command to the client.
+func sendExitStatus(ch ssh.Channel, status uint32) {
+	payload := ssh.Marshal(struct{ Status uint32 }{status})
+	if _, err := ch.SendRequest("exit-status", false, payload); err != nil {
+		log.WithError(err).Debug("failed to send ssh exit status")
+	}
+}
@@ -0,0 +1,184 @@
+package git
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestParseSSHCommand tests parsing the commands executed by git clients.
+func TestParseSSHCommand(t *testing.T) {
+	tests := []struct {
+		cmd     string
+		service string
+		repo    string
+	}{
+		{"git-upload-pack '/abc.git'", "upload-pack", "abc"},
+		{"git-receive-pack 'abc.git'", "receive-pack", "abc"},
+		{"git-upload-pack '/abc'", "upload-pack", "abc"},
+		{"git-upload-pack abc", "upload-pack", "abc"},
+	}
+	for _, test := range tests {
+		service, repo, err := ParseSSHCommand(test.cmd)
+		if err != nil {
+			t.Errorf("%q should be parsed: %s", test.cmd, err)
+			continue
+		}
+		if service != test.service || repo != test.repo {
+			t.Errorf("%q: expected %s %s, got %s %s", test.cmd, test.service, test.repo, service, repo)
+		}
+	}
+
+	for _, cmd := range []string{
+		"", "ls", "git-upload-archive '/abc.git'", "git-upload-pack", "git-upload-pack ''",
+		"git-upload-pack '/a/../b.git'",
+	} {
+		if _, _, err := ParseSSHCommand(cmd); !errors.Is(err, ErrInvalidSSHCommand) {
+			t.Errorf("%q should be invalid, got %v", cmd, err)
+		}
+	}
+}
+
+// TestLoadHostKey tests that the host key is generated once and loaded later.
+func TestLoadHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh", "host_key")
+
+	key, err := LoadHostKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadHostKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key.PublicKey().Marshal(), loaded.PublicKey().Marshal()) {
+		t.Error("the generated host key should be loaded")
+	}
+}
+
+// TestServeSSH tests that the git commands of authenticated clients are passed to the handler.
+func TestServeSSH(t *testing.T) {
+	hostKey, err := LoadHostKey(filepath.Join(t.TempDir(), "host_key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), clientKey.PublicKey().Marshal()) {
+				return nil, errors.New("unknown public key")
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"user": "alice"}}, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go ServeSSH(l, config, func(req *SSHRequest, ch ssh.Channel) uint32 {
+		input, _ := io.ReadAll(ch)
+		io.WriteString(ch, strings.Join([]string{
+			req.Permissions.Extensions["user"], req.Service, req.Repo,
+			strings.Join(req.Env, ","), string(input),
+		}, " "))
+		return 3
+	})
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "git",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientKey)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Setenv("GIT_PROTOCOL", "version=2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Setenv("LANG", "C"); err != nil {
+		t.Fatal(err)
+	}
+	session.Stdin = strings.NewReader("0000")
+	stdout := &bytes.Buffer{}
+	session.Stdout = stdout
+	err = session.Run("git-upload-pack '/abc.git'")
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+	if expected := "alice upload-pack abc GIT_PROTOCOL=version=2 0000"; stdout.String() != expected {
+		t.Errorf("expected %q, got %q", expected, stdout.String())
+	}
+
+	session, err = client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Run("ls")
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+		t.Errorf("other commands should fail, got %v", err)
+	}
+}
+
+// TestOpenWithPackStream tests that the pack is read until its end,
+// since a git client keeps the connection open after it over ssh.
+func TestOpenWithPackStream(t *testing.T) {
+	base, _ := commit(t, "a.txt")
+	pushed, pushedHash := commit(t, "b.txt")
+
+	hashes := []plumbing.Hash{}
+	iter, err := pushed.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := iter.ForEach(func(obj plumbing.EncodedObject) error {
+		hashes = append(hashes, obj.Hash())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pack := &bytes.Buffer{}
+	if _, err := packfile.NewEncoder(pack, pushed.Storer, false).Encode(hashes, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// The writer is not closed, reading after the pack blocks.
+	r, w := io.Pipe()
+	defer w.Close()
+	go w.Write(pack.Bytes())
+
+	repo, err := OpenWithPack(base, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CommitObject(pushedHash); err != nil {
+		t.Errorf("commit %s should be found: %s", pushedHash, err)
+	}
+}
@@ -0,0 +1,120 @@
+package judge
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job is a cancellable unit of work, like a build of a problem or a judging of a submission.
+//
+// A job owns a context, all the requests created with the context of the job will be aborted
+// when the job is cancelled.
+type Job struct {
+	// ID is the ID of the job.
+	ID uuid.UUID `json:"id"`
+
+	// Kind is the kind of the job, like "build".
+	Kind string `json:"kind"`
+
+	// Target is a description of what the job works on, like the ID of a problem.
+	Target string `json:"target"`
+
+	// CreatedAt is the time when the job is created.
+	CreatedAt time.Time `json:"created_at"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var (
+	jobs   = make(map[uuid.UUID]*Job)
+	jobsMu sync.RWMutex
+
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// NewJob creates a job and registers it, so that it can be found and cancelled by its ID.
+//
+// The context of the job is derived from the parent context.
+// Finish must be called when the job is done.
+func NewJob(parent context.Context, kind string, target string) *Job {
+	ctx, cancel := context.WithCancel(parent)
+	job := &Job{
+		ID:        uuid.New(),
+		Kind:      kind,
+		Target:    target,
+		CreatedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	jobs[job.ID] = job
+
+	return job
+}
+
+// Context returns the context of the job.
+func (job *Job) Context() context.Context {
+	return job.ctx
+}
+
+// Cancelled returns true if the job is cancelled.
+func (job *Job) Cancelled() bool {
+	return job.ctx.Err() != nil
+}
+
+// Cancel cancels the job.
+//
+// All the running tasks of the job will be aborted and the queued tasks will never start.
+func (job *Job) Cancel() {
+	job.cancel()
+}
+
+// Finish releases the context of the job and unregisters it.
+func (job *Job) Finish() {
+	job.cancel()
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	delete(jobs, job.ID)
+}
+
+// GetJob returns a running job by its ID.
+func GetJob(id uuid.UUID) (*Job, error) {
+	jobsMu.RLock()
+	defer jobsMu.RUnlock()
+	job, ok := jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// ListJobs returns all the running jobs, sorted by their creation time.
+func ListJobs() []*Job {
+	jobsMu.RLock()
+	defer jobsMu.RUnlock()
+	list := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, job)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// CancelJob cancels a running job by its ID.
+func CancelJob(id uuid.UUID) error {
+	job, err := GetJob(id)
+	if err != nil {
+		return err
+	}
+	job.Cancel()
+	return nil
+}
@@ -0,0 +1,59 @@
+package judge
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/criyle/go-judge/pb"
+)
+
+// TestCancelJob tests that a cancelled job can not start its requests,
+// and all the tasks in the request chain are reported as cancelled.
+func TestCancelJob(t *testing.T) {
+	job := NewJob(context.Background(), "test", "cancel")
+	defer job.Finish()
+
+	if _, err := GetJob(job.ID); err != nil {
+		t.Fatalf("job should be registered, but %v", err)
+	}
+
+	if err := CancelJob(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !job.Cancelled() {
+		t.Fatal("job should be cancelled")
+	}
+
+	cancelled := 0
+	cb := func(r *pb.Response_Result, err error) bool {
+		if r != nil || !errors.Is(err, context.Canceled) {
+			t.Errorf("task should be cancelled, but got result %v and error %v", r, err)
+		}
+		cancelled++
+		return true
+	}
+
+	// The executor should never be called, so it can be nil.
+	j := newJudge("test", nil, 1)
+	j.process(NewRequest(job.Context()).
+		Execute(DefaultTask().WithCallback(cb), DefaultTask().WithCallback(cb)).
+		Then(DefaultTask().WithCallback(cb)))
+
+	if cancelled != 3 {
+		t.Errorf("3 tasks should be cancelled, but %d", cancelled)
+	}
+}
+
+// TestFinishJob tests that a finished job is unregistered.
+func TestFinishJob(t *testing.T) {
+	job := NewJob(context.Background(), "test", "finish")
+	job.Finish()
+
+	if _, err := GetJob(job.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("job should not be found, but %v", err)
+	}
+	if err := CancelJob(job.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("job should not be found, but %v", err)
+	}
+}
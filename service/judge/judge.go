@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"rindag/service/etc"
+	"rindag/service/metrics"
 
 	"github.com/criyle/go-judge/pb"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
@@ -21,52 +23,159 @@ import (
 // Judge is a judge server.
 // It is a backend for go-judge.
 type Judge struct {
+	// id is the id of the judge.
+	id string
+
 	// execClient is the client for executing programs.
 	execClient pb.ExecutorClient
 
-	// requests is the channel for receiving requests.
-	requests chan *Request
+	// queue is the queue of tasks waiting to be executed.
+	queue *taskQueue
+
+	// parallelism is the maximum number of tasks executed at the same time.
+	parallelism int
+
+	// running is the number of tasks being executed.
+	running int64
 }
 
+// DefaultParallelism is the parallelism of a judge if it is not set in config.
+const DefaultParallelism = 4
+
 // judges is a collection of judges.
 var (
 	judges           = make(map[string]*Judge)
 	ErrJudgeNotFound = errors.New("judge not found")
 	ErrJudgeExists   = errors.New("judge already exists")
+	ErrTaskAborted   = errors.New("task aborted by another task of the request")
 )
 
 // NewJudge creates a new Judge.
-func newJudge(execClient pb.ExecutorClient) *Judge {
+func newJudge(id string, execClient pb.ExecutorClient, parallelism int) *Judge {
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
 	return &Judge{
-		execClient: execClient,
-		requests:   make(chan *Request, 64),
+		id:          id,
+		execClient:  execClient,
+		queue:       newTaskQueue(),
+		parallelism: parallelism,
 	}
 }
 
-func (j *Judge) processSingleTask(
-	parentCtx context.Context, parentCancel context.CancelFunc, task *Task,
-) {
-	// Create a new context for the task, and cancel it when the parent context is ended.
+// taskContext creates the context of an execution of a task.
+//
+// It is cancelled when the parent context is ended, or the task is stopped.
+func taskContext(
+	parentCtx context.Context, task *Task,
+) (context.Context, context.CancelFunc, error) {
 	ctx, cancel := context.WithTimeout(
 		parentCtx, time.Duration(2*task.TimeLimit)*time.Millisecond+30*time.Second)
+	if !task.setCancel(cancel) {
+		cancel()
+		return nil, nil, ErrTaskStopped
+	}
+	return ctx, cancel, nil
+}
+
+// exec executes a request once.
+//
+// The second return value is true if the failure is transient, and the request can be retried.
+func (j *Judge) exec(
+	parentCtx context.Context, task *Task, pbr *pb.Request,
+) (*pb.Response, bool, error) {
+	if task.Streaming() {
+		return j.execStream(parentCtx, task, pbr)
+	}
+	ctx, cancel, err := taskContext(parentCtx, task)
+	if err != nil {
+		return nil, false, err
+	}
 	defer cancel()
-	pbr := task.ToPbRequest()
 	result, err := j.execClient.Exec(ctx, pbr)
-	if err != nil || len(result.Results) == 0 {
+	if err != nil {
+		if task.Stopped() {
+			return nil, false, ErrTaskStopped
+		}
+		return nil, isTransientError(ctx, err), err
+	}
+	return result, isTransientResult(result), nil
+}
+
+// processSingleTask executes a task and calls its callback.
+//
+// Transient failures are retried with backoff at most MaxRetries times, except streaming tasks.
+// The task is always retried on the same judge, as the cached files it uses are only on it.
+func (j *Judge) processSingleTask(
+	req *Request, parentCtx context.Context, parentCancel context.CancelFunc, task *Task,
+) {
+	var (
+		result *pb.Response
+		err    error
+	)
+	// An invalid task aborts the request, like a task failed to execute.
+	if err := task.validate(); err != nil {
+		parentCancel()
+		log.WithField("task", task.ID).WithError(err).Error("Invalid task")
+		task.fail(err)
+		return
+	}
+
+	start := time.Now()
+	pbr := task.ToPbRequest()
+	for retry := 0; ; retry++ {
+		var transient bool
+		result, transient, err = j.exec(parentCtx, task, pbr)
+		if !transient || retry >= MaxRetries || parentCtx.Err() != nil {
+			break
+		}
+		delay := retryDelay(retry)
+		log.WithField("task", task.ID).WithField("retry", retry+1).WithError(err).
+			Warnf("Transient failure, retry in %s", delay)
+		select {
+		case <-parentCtx.Done():
+		case <-time.After(delay):
+		}
+	}
+	metrics.TaskDuration.WithLabelValues(task.Kind).Observe(time.Since(start).Seconds())
+	cmds := task.Commands()
+	if errors.Is(err, ErrTaskStopped) {
+		// A stopped task does not abort the other tasks of the request.
+		log.WithField("task", task.ID).Info("Stopped")
+		task.fail(err)
+		return
+	}
+	if err != nil || len(result.Results) < len(cmds) {
 		// Failed to execute.
 		select {
 		case <-parentCtx.Done():
-			// If the parent context is cancelled, do nothing.
+			// If the request is cancelled by its owner, report the cancellation to the task.
+			// Otherwise another task of the request has aborted it.
+			if reqErr := req.ctx.Err(); reqErr != nil {
+				log.WithField("task", task.ID).Info("Cancelled")
+				task.fail(reqErr)
+			} else {
+				log.WithField("task", task.ID).Info("Aborted")
+				task.fail(ErrTaskAborted)
+			}
 		default:
 			parentCancel()
 			log.WithField("task", task.ID).WithError(err).Error("Failed to execute")
-			task.Callback(nil, err)
+			task.fail(err)
 		}
 		return
 	}
 	// Executed successfully
 	log.WithField("task", task.ID).Debug("Executed successfully")
-	if !task.Callback(result.Results[0], nil) {
+	for i, c := range cmds {
+		metrics.Verdicts.WithLabelValues(c.Kind, result.Results[i].Status.String()).Inc()
+	}
+	ok := true
+	for i, p := range task.Piped {
+		ok = p.Callback(result.Results[i+1], nil) && ok
+	}
+	ok = task.Callback(result.Results[0], nil) && ok
+	if !ok {
 		log.WithField("task", task.ID).Info("Aborted")
 		parentCancel()
 	}
@@ -75,45 +184,85 @@ func (j *Judge) processSingleTask(
 
 // process processes a request.
 func (j *Judge) process(req *Request) {
+	if err := req.ctx.Err(); err != nil {
+		// The request is cancelled before it starts, so none of its tasks will be executed.
+		log.WithField("request", req.ID).Info("Cancelled")
+		req.fail(err)
+		return
+	}
 	log.WithField("request", req.ID).Debug("Processing request")
 	parentCtx, parentCancel := context.WithCancel(req.ctx)
 	defer parentCancel()
 	wg := sync.WaitGroup{}
 	wg.Add(len(req.Tasks))
-	// Process each task in parallel
+	// Queue each task, they will be processed in parallel by the workers.
+	metrics.JudgeQueueLength.WithLabelValues(j.id).Add(float64(len(req.Tasks)))
 	for _, task := range req.Tasks {
-		go func(task *Task) {
-			j.processSingleTask(parentCtx, parentCancel, task)
-			wg.Done()
-		}(task)
+		j.queue.push(&queuedTask{
+			req:          req,
+			parentCtx:    parentCtx,
+			parentCancel: parentCancel,
+			task:         task,
+			done:         wg.Done,
+		})
 	}
 	wg.Wait()
 	select {
 	case <-parentCtx.Done():
-		// If the parent context is cancelled, do nothing
+		// The sub-requests will never start, but their callbacks are still called.
+		if err := req.ctx.Err(); err != nil {
+			// The request is cancelled by its owner.
+			log.WithField("request", req.ID).Info("Cancelled")
+			if req.SubRequest != nil {
+				req.SubRequest.fail(err)
+			}
+			return
+		}
 		log.WithField("request", req.ID).Info("Aborted")
+		if req.SubRequest != nil {
+			req.SubRequest.fail(ErrTaskAborted)
+		}
 		return
 	default:
 		break // All tasks are finished, do nothing
 	}
-	// Add the sub-request to process channel
+	log.WithField("request", req.ID).Debug("Finished processing request")
+	// Process the sub-request after all tasks are finished.
 	if req.SubRequest != nil {
-		j.requests <- req.SubRequest
+		j.process(req.SubRequest)
 	}
-	log.WithField("request", req.ID).Debug("Finished processing request")
 }
 
-// Start starts the judge.
+// Start starts the workers of the judge.
 func (j *Judge) start() {
-	go func() {
-		for req := range j.requests {
-			go j.process(req)
-		}
-	}()
+	queueLength := metrics.JudgeQueueLength.WithLabelValues(j.id)
+	inFlight := metrics.JudgeInFlight.WithLabelValues(j.id)
+	for i := 0; i < j.parallelism; i++ {
+		go func() {
+			for {
+				t := j.queue.pop()
+				queueLength.Dec()
+				inFlight.Inc()
+				atomic.AddInt64(&j.running, 1)
+				j.processSingleTask(t.req, t.parentCtx, t.parentCancel, t.task)
+				atomic.AddInt64(&j.running, -1)
+				inFlight.Dec()
+				t.done()
+			}
+		}()
+	}
 }
 
+// AddRequest adds a request to the judge.
+//
+// Its tasks are queued by the priority and key of the request.
 func (j *Judge) AddRequest(req *Request) {
-	j.requests <- req
+	go j.process(req)
+}
+
+// Load returns the number of tasks queued and being executed by the judge.
+func (j *Judge) Load() int {
+	return j.queue.len() + int(atomic.LoadInt64(&j.running))
 }
 
 func (j *Judge) FileList(ctx context.Context) (map[string]string, error) {
@@ -160,10 +309,10 @@ func GetIdleJudge() (string, *Judge, error) {
 		idleCount   int
 	)
 	for id, j := range judges {
-		if idleJudge == nil || len(j.requests) < idleCount {
+		if load := j.Load(); idleJudge == nil || load < idleCount {
 			idleJudge = j
 			idleJudgeID = id
-			idleCount = len(j.requests)
+			idleCount = load
 		}
 	}
 	if idleJudge == nil {
@@ -172,10 +321,17 @@ func GetIdleJudge() (string, *Judge, error) {
 	return idleJudgeID, idleJudge, nil
 }
 
-func AddAndStart(id string, host string, token string) error {
+func AddAndStart(id string, host string, token string, parallelism int) error {
 	if _, ok := judges[id]; ok {
 		return ErrJudgeExists
 	}
+	if host == LocalHost {
+		log.WithField("id", id).Warn("Using local executor, commands are not sandboxed")
+		j := newJudge(id, NewLocalExecutor(), parallelism)
+		j.start()
+		judges[id] = j
+		return nil
+	}
 	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(
@@ -196,7 +352,7 @@ func AddAndStart(id string, host string, token string) error {
 		log.WithError(err).Fatal("Failed to dial")
 	}
 	execClient := pb.NewExecutorClient(conn)
-	j := newJudge(execClient)
+	j := newJudge(id, execClient, parallelism)
 	j.start()
 	judges[id] = j
 	return nil
@@ -206,7 +362,7 @@ func init() {
 	// Initialize judges from config
 	for id, c := range etc.Config.Judges {
 		log.WithField("id", id).Debug("Initializing judge")
-		if err := AddAndStart(id, c.Host, c.Token); err != nil {
+		if err := AddAndStart(id, c.Host, c.Token, c.Parallelism); err != nil {
 			log.WithError(err).WithField("id", id).Fatal("Failed to initialize judge")
 		}
 	}
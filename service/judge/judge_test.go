@@ -2,10 +2,14 @@ package judge
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"testing"
 
 	"github.com/criyle/go-judge/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // TestEcho is a test for output "Hello, world!" by /bin/echo.
@@ -146,3 +150,49 @@ func TestAPlusB(t *testing.T) {
 	judge.AddRequest(NewRequest(context.TODO()).Execute(compileTask).Then(runTask))
 	wg.Wait()
 }
+
+// failingExecutor is an executor which fails the commands named "fail" permanently.
+type failingExecutor struct {
+	pb.ExecutorClient
+}
+
+func (e *failingExecutor) Exec(
+	ctx context.Context, req *pb.Request, _ ...grpc.CallOption,
+) (*pb.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	if req.Cmd[0].Args[0] == "fail" {
+		return nil, status.Error(codes.InvalidArgument, "bad request")
+	}
+	return &pb.Response{Results: []*pb.Response_Result{{Status: pb.Response_Result_Accepted}}}, nil
+}
+
+// TestAbortCallbacks tests that the callbacks of the tasks aborted by another task of the request,
+// and the tasks of its sub-requests, are called with ErrTaskAborted.
+func TestAbortCallbacks(t *testing.T) {
+	j := newJudge("test", &failingExecutor{}, 1)
+	j.start()
+	wg := &sync.WaitGroup{}
+	errs := make([]error, 4)
+	newTask := func(i int, name string) *Task {
+		wg.Add(1)
+		return DefaultTask().WithCmd(name).WithCallback(func(_ *pb.Response_Result, err error) bool {
+			errs[i] = err
+			wg.Done()
+			return true
+		})
+	}
+	j.AddRequest(NewRequest(context.Background()).
+		Execute(newTask(0, "fail"), newTask(1, "ok"), newTask(2, "ok")).
+		Then(newTask(3, "ok")))
+	wg.Wait()
+	if status.Code(errs[0]) != codes.InvalidArgument {
+		t.Errorf("task 0 should fail with InvalidArgument, but %v", errs[0])
+	}
+	for i := 1; i < len(errs); i++ {
+		if !errors.Is(errs[i], ErrTaskAborted) {
+			t.Errorf("task %d should be aborted, but %v", i, errs[i])
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package judge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/criyle/go-judge/pb"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// LocalHost is the host of a judge to use the local executor instead of a go-judge server.
+const LocalHost = "local"
+
+// LocalExecutor is an executor which runs commands on the local machine.
+//
+// It is for development and tests only.
+// Each command runs in its own temporary directory, and its CPU time and memory are limited
+// by rlimits, but it is not isolated from the host, and the process limit is not supported.
+// The memory limit is of the address space, which is larger than the memory used,
+// and an allocation over it fails in the command instead of MemoryLimitExceeded.
+//
+// It implements pb.ExecutorClient, so it can be used as the backend of a judge.
+type LocalExecutor struct {
+	// files is the in-memory file cache, maps file ID to file.
+	files   map[string]*pb.FileContent
+	filesMu sync.RWMutex
+}
+
+// NewLocalExecutor creates a local executor.
+func NewLocalExecutor() *LocalExecutor {
+	return &LocalExecutor{files: make(map[string]*pb.FileContent)}
+}
+
+// localCmd is a command being executed by the local executor.
+type localCmd struct {
+	spec *pb.Request_CmdType
+
+	// root is the temporary directory of the command, work dir is "<root>/work".
+	root string
+	dir  string
+
+	// fds are the files of the child, indexed by file descriptors.
+	fds []*os.File
+
+	// collectors are the pipe collectors of the command, indexed by name.
+	collectors map[string]*localCollector
+
+	cmd      *exec.Cmd
+	start    time.Time
+	runTime  time.Duration
+	timedOut bool
+	err      error
+}
+
+// localCollector collects the output of a pipe, at most max bytes are kept.
+type localCollector struct {
+	r        *os.File
+	max      int64
+	buf      bytes.Buffer
+	exceeded bool
+	done     chan struct{}
+}
+
+func (c *localCollector) collect() {
+	defer close(c.done)
+	defer c.r.Close()
+	n, _ := io.Copy(&c.buf, io.LimitReader(c.r, c.max))
+	if n >= c.max {
+		// Drain the rest, so that the writer will not be blocked.
+		if extra, _ := io.Copy(io.Discard, c.r); extra > 0 {
+			c.exceeded = true
+		}
+	}
+}
+
+func (e *LocalExecutor) readCached(fileID string) ([]byte, error) {
+	e.filesMu.RLock()
+	defer e.filesMu.RUnlock()
+	f, ok := e.files[fileID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", fileID)
+	}
+	return f.Content, nil
+}
+
+func (e *LocalExecutor) addCached(name string, content []byte) string {
+	e.filesMu.Lock()
+	defer e.filesMu.Unlock()
+	id := uuid.NewString()
+	e.files[id] = &pb.FileContent{Name: name, Content: content}
+	return id
+}
+
+// fileContent returns the content of a memory or cached file.
+func (e *LocalExecutor) fileContent(f *pb.Request_File) ([]byte, bool, error) {
+	switch f := f.File.(type) {
+	case *pb.Request_File_Memory:
+		return f.Memory.Content, true, nil
+	case *pb.Request_File_Cached:
+		content, err := e.readCached(f.Cached.FileID)
+		return content, true, err
+	case *pb.Request_File_Local:
+		content, err := os.ReadFile(f.Local.Src)
+		return content, true, err
+	default:
+		return nil, false, nil
+	}
+}
+
+// prepare creates the directory and the files of a command.
+func (e *LocalExecutor) prepare(spec *pb.Request_CmdType) (*localCmd, error) {
+	root, err := os.MkdirTemp("", "rindag-local-")
+	if err != nil {
+		return nil, err
+	}
+	c := &localCmd{
+		spec:       spec,
+		root:       root,
+		dir:        filepath.Join(root, "work"),
+		fds:        make([]*os.File, len(spec.Files)),
+		collectors: make(map[string]*localCollector),
+	}
+	if err := os.Mkdir(c.dir, 0o755); err != nil {
+		return c, err
+	}
+
+	for name, f := range spec.CopyIn {
+		content, ok, err := e.fileContent(f)
+		if err != nil {
+			return c, err
+		}
+		if !ok {
+			return c, status.Errorf(codes.InvalidArgument, "unsupported copy in file %s", name)
+		}
+		pa := filepath.Join(c.dir, filepath.Clean("/"+name))
+		if err := os.MkdirAll(filepath.Dir(pa), 0o755); err != nil {
+			return c, err
+		}
+		if err := os.WriteFile(pa, content, 0o755); err != nil {
+			return c, err
+		}
+	}
+
+	for fd, f := range spec.Files {
+		if f.GetFile() == nil {
+			// Connected by a pipe mapping, or not used.
+			continue
+		}
+		if p := f.GetPipe(); p != nil {
+			r, w, err := os.Pipe()
+			if err != nil {
+				return c, err
+			}
+			c.fds[fd] = w
+			c.collectors[p.Name] = &localCollector{r: r, max: p.Max, done: make(chan struct{})}
+			continue
+		}
+		content, ok, err := e.fileContent(f)
+		if err != nil {
+			return c, err
+		}
+		if !ok {
+			return c, status.Errorf(codes.InvalidArgument, "unsupported file of fd %d", fd)
+		}
+		pa := filepath.Join(root, fmt.Sprintf("fd%d", fd))
+		if err := os.WriteFile(pa, content, 0o644); err != nil {
+			return c, err
+		}
+		if c.fds[fd], err = os.Open(pa); err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+// setFd sets the file of a file descriptor of the command.
+func (c *localCmd) setFd(fd int32, f *os.File) {
+	for int(fd) >= len(c.fds) {
+		c.fds = append(c.fds, nil)
+	}
+	c.fds[fd] = f
+}
+
+// command creates the exec.Cmd of the command.
+//
+// The command is wrapped by "/bin/sh" to set the rlimits before it is executed.
+func (c *localCmd) command() *exec.Cmd {
+	spec := c.spec
+	args := append([]string{}, spec.Args...)
+	if len(args) > 0 && !strings.Contains(args[0], "/") {
+		if _, err := os.Stat(filepath.Join(c.dir, args[0])); err == nil {
+			args[0] = "./" + args[0]
+		}
+	}
+	limits := []string{}
+	if spec.CpuTimeLimit > 0 {
+		seconds := (spec.CpuTimeLimit + uint64(time.Second) - 1) / uint64(time.Second)
+		limits = append(limits, fmt.Sprintf("ulimit -t %d", seconds))
+	}
+	if spec.MemoryLimit > 0 {
+		// The data segment limit does not cover all the memory allocated by mmap.
+		limits = append(limits, fmt.Sprintf("ulimit -v %d", spec.MemoryLimit/1024))
+	}
+	script := strings.Join(append(limits, `exec "$0" "$@"`), "; ")
+	cmd := exec.Command("/bin/sh", append([]string{"-c", script}, args...)...)
+	cmd.Dir = c.dir
+	cmd.Env = spec.Env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if len(c.fds) > 0 && c.fds[0] != nil {
+		cmd.Stdin = c.fds[0]
+	}
+	if len(c.fds) > 1 && c.fds[1] != nil {
+		cmd.Stdout = c.fds[1]
+	}
+	if len(c.fds) > 2 && c.fds[2] != nil {
+		cmd.Stderr = c.fds[2]
+	}
+	if len(c.fds) > 3 {
+		cmd.ExtraFiles = c.fds[3:]
+	}
+	return cmd
+}
+
+// kill kills the process group of the command.
+func (c *localCmd) kill() {
+	if c.cmd != nil && c.cmd.Process != nil {
+		_ = syscall.Kill(-c.cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// closeFds closes the files of the child in the parent process.
+func (c *localCmd) closeFds() {
+	for _, f := range c.fds {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+// result returns the result of a finished command.
+func (c *localCmd) result(e *LocalExecutor) *pb.Response_Result {
+	spec := c.spec
+	r := &pb.Response_Result{
+		Files:   make(map[string][]byte),
+		FileIDs: make(map[string]string),
+		RunTime: uint64(c.runTime),
+	}
+	if c.err != nil {
+		r.Status = pb.Response_Result_InternalError
+		r.Error = c.err.Error()
+		return r
+	}
+
+	state := c.cmd.ProcessState
+	ws, _ := state.Sys().(syscall.WaitStatus)
+	r.ExitStatus = int32(state.ExitCode())
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+		r.Time = uint64(ru.Utime.Nano() + ru.Stime.Nano())
+		r.Memory = uint64(ru.Maxrss) * 1024
+	}
+
+	outputExceeded := false
+	for _, col := range c.collectors {
+		outputExceeded = outputExceeded || col.exceeded
+	}
+
+	switch {
+	case c.timedOut || (spec.CpuTimeLimit > 0 && r.Time > spec.CpuTimeLimit) ||
+		(ws.Signaled() && ws.Signal() == syscall.SIGXCPU):
+		r.Status = pb.Response_Result_TimeLimitExceeded
+	case spec.MemoryLimit > 0 && r.Memory > spec.MemoryLimit:
+		r.Status = pb.Response_Result_MemoryLimitExceeded
+	case outputExceeded:
+		r.Status = pb.Response_Result_OutputLimitExceeded
+	case ws.Signaled():
+		r.Status = pb.Response_Result_Signalled
+	case ws.ExitStatus() != 0:
+		r.Status = pb.Response_Result_NonZeroExitStatus
+	default:
+		r.Status = pb.Response_Result_Accepted
+	}
+
+	copyOut := func(f *pb.Request_CmdCopyOutFile) ([]byte, bool) {
+		if col, ok := c.collectors[f.Name]; ok {
+			return col.buf.Bytes(), true
+		}
+		content, err := os.ReadFile(filepath.Join(c.dir, filepath.Clean("/"+f.Name)))
+		if err != nil {
+			if !f.Optional {
+				r.FileError = append(r.FileError, &pb.Response_FileError{
+					Name:    f.Name,
+					Type:    pb.Response_FileError_CopyOutOpen,
+					Message: err.Error(),
+				})
+			}
+			return nil, false
+		}
+		return content, true
+	}
+	for _, f := range spec.CopyOut {
+		if content, ok := copyOut(f); ok {
+			r.Files[f.Name] = content
+		}
+	}
+	for _, f := range spec.CopyOutCached {
+		if content, ok := copyOut(f); ok {
+			r.FileIDs[f.Name] = e.addCached(f.Name, content)
+		}
+	}
+	if len(r.FileError) > 0 && r.Status == pb.Response_Result_Accepted {
+		r.Status = pb.Response_Result_FileError
+	}
+
+	return r
+}
+
+// Exec runs the commands of the request on the local machine.
+func (e *LocalExecutor) Exec(
+	ctx context.Context, in *pb.Request, _ ...grpc.CallOption,
+) (*pb.Response, error) {
+	cmds := make([]*localCmd, 0, len(in.Cmd))
+	defer func() {
+		for _, c := range cmds {
+			c.closeFds()
+			os.RemoveAll(c.root)
+		}
+	}()
+
+	for _, spec := range in.Cmd {
+		c, err := e.prepare(spec)
+		if c != nil {
+			cmds = append(cmds, c)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range in.PipeMapping {
+		if int(p.In.Index) >= len(cmds) || int(p.Out.Index) >= len(cmds) {
+			return nil, status.Error(codes.InvalidArgument, "pipe refers to an unknown command")
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		cmds[p.In.Index].setFd(p.In.Fd, w)
+		cmds[p.Out.Index].setFd(p.Out.Fd, r)
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, c := range cmds {
+		c.cmd = c.command()
+		c.start = time.Now()
+		if c.err = c.cmd.Start(); c.err != nil {
+			continue
+		}
+		for _, col := range c.collectors {
+			go col.collect()
+		}
+		wg.Add(1)
+		go func(c *localCmd) {
+			defer wg.Done()
+			done := make(chan struct{})
+			watched := make(chan struct{})
+			go func() {
+				defer close(watched)
+				var timeout <-chan time.Time
+				if c.spec.ClockTimeLimit > 0 {
+					timer := time.NewTimer(time.Duration(c.spec.ClockTimeLimit))
+					defer timer.Stop()
+					timeout = timer.C
+				}
+				select {
+				case <-done:
+				case <-ctx.Done():
+					c.kill()
+				case <-timeout:
+					c.timedOut = true
+					c.kill()
+				}
+			}()
+			_ = c.cmd.Wait()
+			c.runTime = time.Since(c.start)
+			close(done)
+			<-watched
+		}(c)
+	}
+
+	// The children have their own copies of the files now.
+	for _, c := range cmds {
+		c.closeFds()
+		c.fds = nil
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+
+	res := &pb.Response{Results: make([]*pb.Response_Result, len(cmds))}
+	for i, c := range cmds {
+		for _, col := range c.collectors {
+			if c.err == nil {
+				<-col.done
+			} else {
+				col.r.Close()
+			}
+		}
+		res.Results[i] = c.result(e)
+	}
+	return res, nil
+}
+
+// ExecStream is not supported by the local executor.
+func (e *LocalExecutor) ExecStream(
+	_ context.Context, _ ...grpc.CallOption,
+) (pb.Executor_ExecStreamClient, error) {
+	return nil, status.Error(codes.Unimplemented, "local executor does not support streaming")
+}
+
+// FileList lists all the cached files.
+func (e *LocalExecutor) FileList(
+	_ context.Context, _ *emptypb.Empty, _ ...grpc.CallOption,
+) (*pb.FileListType, error) {
+	e.filesMu.RLock()
+	defer e.filesMu.RUnlock()
+	ids := make(map[string]string, len(e.files))
+	for id, f := range e.files {
+		ids[id] = f.Name
+	}
+	return &pb.FileListType{FileIDs: ids}, nil
+}
+
+// FileGet returns a cached file.
+func (e *LocalExecutor) FileGet(
+	_ context.Context, in *pb.FileID, _ ...grpc.CallOption,
+) (*pb.FileContent, error) {
+	e.filesMu.RLock()
+	defer e.filesMu.RUnlock()
+	f, ok := e.files[in.FileID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", in.FileID)
+	}
+	return f, nil
+}
+
+// FileAdd adds a file to the cache.
+func (e *LocalExecutor) FileAdd(
+	_ context.Context, in *pb.FileContent, _ ...grpc.CallOption,
+) (*pb.FileID, error) {
+	return &pb.FileID{FileID: e.addCached(in.Name, in.Content)}, nil
+}
+
+// FileDelete deletes a cached file.
+func (e *LocalExecutor) FileDelete(
+	_ context.Context, in *pb.FileID, _ ...grpc.CallOption,
+) (*emptypb.Empty, error) {
+	e.filesMu.Lock()
+	defer e.filesMu.Unlock()
+	if _, ok := e.files[in.FileID]; !ok {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", in.FileID)
+	}
+	delete(e.files, in.FileID)
+	return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,114 @@
+package judge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/criyle/go-judge/pb"
+)
+
+// TestLocalEcho tests that the local executor collects stdout to the file cache.
+func TestLocalEcho(t *testing.T) {
+	e := NewLocalExecutor()
+	res, err := e.Exec(context.Background(),
+		DefaultTask().WithCmd("/bin/sh", "-c", "echo -n Hello, World!").ToPbRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := res.Results[0]
+	if r.Status != pb.Response_Result_Accepted {
+		t.Fatalf("status should be Accepted, but %v: %s", r.Status, r.Files["stderr"])
+	}
+	stdout, err := e.FileGet(context.Background(), &pb.FileID{FileID: r.FileIDs["stdout"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stdout.Content) != "Hello, World!" {
+		t.Errorf("stdout should be \"Hello, World!\", but \"%s\"", stdout.Content)
+	}
+}
+
+// TestLocalCopyIn tests that the copied in files are in the work directory of the command,
+// and the cached files can be copied in.
+func TestLocalCopyIn(t *testing.T) {
+	e := NewLocalExecutor()
+	id, err := e.FileAdd(context.Background(), &pb.FileContent{Content: []byte("1 2\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.Exec(context.Background(), DefaultTask().
+		WithCmd("run.sh").
+		WithCopyIn("run.sh", []byte("#!/bin/sh\nread a b < input.txt\necho $((a + b)) > output.txt\n")).
+		WithCopyInCached("input.txt", &id.FileID).
+		WithCopyOut("output.txt").
+		ToPbRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := res.Results[0]
+	if r.Status != pb.Response_Result_Accepted {
+		t.Fatalf("status should be Accepted, but %v: %s", r.Status, r.Files["stderr"])
+	}
+	output, err := e.FileGet(context.Background(), &pb.FileID{FileID: r.FileIDs["output.txt"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output.Content) != "3\n" {
+		t.Errorf("output should be \"3\\n\", but \"%s\"", output.Content)
+	}
+}
+
+// TestLocalPipe tests that the stdout of a command can be piped to the stdin of another.
+func TestLocalPipe(t *testing.T) {
+	e := NewLocalExecutor()
+	res, err := e.Exec(context.Background(), DefaultTask().
+		WithCmd("/bin/echo", "piped").
+		WithPiped(DefaultTask().WithCmd("/bin/cat")).
+		WithPipe(0, 1, 1, 0).
+		ToPbRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range res.Results {
+		if r.Status != pb.Response_Result_Accepted {
+			t.Fatalf("status of command %d should be Accepted, but %v", i, r.Status)
+		}
+	}
+	stdout, err := e.FileGet(context.Background(), &pb.FileID{FileID: res.Results[1].FileIDs["stdout"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stdout.Content) != "piped\n" {
+		t.Errorf("stdout should be \"piped\\n\", but \"%s\"", stdout.Content)
+	}
+}
+
+// TestLocalTimeLimit tests that a command is killed when it exceeds the time limit.
+func TestLocalTimeLimit(t *testing.T) {
+	e := NewLocalExecutor()
+	res, err := e.Exec(context.Background(), DefaultTask().
+		WithCmd("/bin/sleep", "10").
+		WithTimeLimit(100*1000*1000). // 100 ms.
+		ToPbRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := res.Results[0]; r.Status != pb.Response_Result_TimeLimitExceeded {
+		t.Errorf("status should be TimeLimitExceeded, but %v", r.Status)
+	}
+}
+
+// TestLocalMemoryLimit tests that the memory allocated by mmap is limited.
+func TestLocalMemoryLimit(t *testing.T) {
+	e := NewLocalExecutor()
+	res, err := e.Exec(context.Background(), DefaultTask().
+		WithCmd("/usr/bin/python3", "-c", "b = bytearray(512 << 20)").
+		WithMemoryLimit(128*1024*1024). // 128 MiB.
+		ToPbRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := res.Results[0]; r.Status == pb.Response_Result_Accepted {
+		t.Errorf("allocating over the memory limit should fail, but %v", r.Status)
+	}
+}
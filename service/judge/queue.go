@@ -0,0 +1,116 @@
+package judge
+
+import (
+	"context"
+	"sync"
+)
+
+// Priority is the priority of a request.
+//
+// Tasks of requests with higher priority are always executed first.
+type Priority int
+
+const (
+	// PriorityLow is for background work, like stress tests.
+	PriorityLow Priority = -1
+
+	// PriorityNormal is the default priority, used by builds of problems.
+	PriorityNormal Priority = 0
+
+	// PriorityHigh is for work that someone is waiting for, like live submissions.
+	PriorityHigh Priority = 1
+)
+
+// queuedTask is a task waiting to be executed by a judge.
+type queuedTask struct {
+	req          *Request
+	parentCtx    context.Context
+	parentCancel context.CancelFunc
+	task         *Task
+
+	// done is called after the task is processed.
+	done func()
+}
+
+// fairQueue is a FIFO queue for each key, the keys are served in round-robin order.
+type fairQueue struct {
+	keys  []string
+	tasks map[string][]*queuedTask
+}
+
+// taskQueue is a priority queue of tasks.
+//
+// Tasks with higher priority are served first,
+// and tasks with the same priority are shared fairly among the keys of their requests.
+type taskQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	levels map[Priority]*fairQueue
+	length int
+}
+
+func newTaskQueue() *taskQueue {
+	q := &taskQueue{levels: make(map[Priority]*fairQueue)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a task to the queue.
+func (q *taskQueue) push(t *queuedTask) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	level, ok := q.levels[t.req.Priority]
+	if !ok {
+		level = &fairQueue{keys: []string{}, tasks: make(map[string][]*queuedTask)}
+		q.levels[t.req.Priority] = level
+	}
+	key := t.req.Key
+	if len(level.tasks[key]) == 0 {
+		level.keys = append(level.keys, key)
+	}
+	level.tasks[key] = append(level.tasks[key], t)
+	q.length++
+
+	q.cond.Signal()
+}
+
+// pop removes and returns the next task to be executed.
+// It blocks until there is a task in the queue.
+func (q *taskQueue) pop() *queuedTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.length == 0 {
+		q.cond.Wait()
+	}
+
+	var level *fairQueue
+	var priority Priority
+	for p, l := range q.levels {
+		if len(l.keys) > 0 && (level == nil || p > priority) {
+			level, priority = l, p
+		}
+	}
+
+	// Take the first task of the first key, and move the key to the back if it has more tasks.
+	key := level.keys[0]
+	level.keys = level.keys[1:]
+	t := level.tasks[key][0]
+	level.tasks[key] = level.tasks[key][1:]
+	if len(level.tasks[key]) > 0 {
+		level.keys = append(level.keys, key)
+	} else {
+		delete(level.tasks, key)
+	}
+	q.length--
+
+	return t
+}
+
+// len returns the number of tasks in the queue.
+func (q *taskQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.length
+}
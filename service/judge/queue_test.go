@@ -0,0 +1,42 @@
+package judge
+
+import (
+	"context"
+	"testing"
+)
+
+// TestTaskQueueOrder tests that the tasks with higher priority are served first,
+// and the tasks with the same priority are served in round-robin order of keys.
+func TestTaskQueueOrder(t *testing.T) {
+	q := newTaskQueue()
+	push := func(priority Priority, key string, n int) []*Task {
+		req := NewRequest(context.Background()).WithPriority(priority).WithKey(key)
+		tasks := make([]*Task, n)
+		for i := range tasks {
+			tasks[i] = DefaultTask()
+			q.push(&queuedTask{req: req, task: tasks[i]})
+		}
+		return tasks
+	}
+
+	rebuild := push(PriorityNormal, "a", 3)
+	other := push(PriorityNormal, "b", 2)
+	stress := push(PriorityLow, "a", 1)
+	submit := push(PriorityHigh, "c", 1)
+
+	expected := []*Task{
+		submit[0],
+		rebuild[0], other[0], rebuild[1], other[1], rebuild[2],
+		stress[0],
+	}
+
+	if q.len() != len(expected) {
+		t.Fatalf("queue length should be %d, but %d", len(expected), q.len())
+	}
+
+	for i, e := range expected {
+		if got := q.pop().task; got != e {
+			t.Errorf("task %d should be %v, but %v", i, e.ID, got.ID)
+		}
+	}
+}
@@ -20,6 +20,15 @@ type Request struct {
 
 	// SubRequest is the sub-request to be executed after the main request.
 	SubRequest *Request
+
+	// Priority is the priority of the request.
+	Priority Priority
+
+	// Key is used to share the capacity of a judge fairly among requests with the same priority,
+	// like the ID of a problem or a tenant.
+	//
+	// Requests with the same key are executed in FIFO order.
+	Key string
 }
 
 // NewRequest creates a new request.
@@ -31,9 +40,27 @@ func NewRequest(
 		ID:         uuid.New(),
 		Tasks:      make([]*Task, 0),
 		SubRequest: nil,
+		Priority:   PriorityNormal,
+		Key:        "",
 	}
 }
 
+// WithPriority sets the priority of the request and all its sub-requests.
+func (r *Request) WithPriority(priority Priority) *Request {
+	for curReq := r; curReq != nil; curReq = curReq.SubRequest {
+		curReq.Priority = priority
+	}
+	return r
+}
+
+// WithKey sets the fair share key of the request and all its sub-requests.
+func (r *Request) WithKey(key string) *Request {
+	for curReq := r; curReq != nil; curReq = curReq.SubRequest {
+		curReq.Key = key
+	}
+	return r
+}
+
 // Execute adds the task to be executed.
 func (r *Request) Execute(task ...*Task) *Request {
 	r.Tasks = append(r.Tasks, task...)
@@ -49,6 +76,19 @@ func (r *Request) Then(task ...*Task) *Request {
 		curReq = curReq.SubRequest
 	}
 	curReq.SubRequest = NewRequest(r.ctx)
+	curReq.SubRequest.Priority = r.Priority
+	curReq.SubRequest.Key = r.Key
 	curReq.SubRequest.Execute(task...)
 	return r
 }
+
+// fail calls the callbacks of all the tasks in the request chain with the given error.
+//
+// It is used when the request is cancelled before the tasks are executed.
+func (r *Request) fail(err error) {
+	for curReq := r; curReq != nil; curReq = curReq.SubRequest {
+		for _, task := range curReq.Tasks {
+			task.fail(err)
+		}
+	}
+}
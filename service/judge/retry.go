@@ -0,0 +1,61 @@
+package judge
+
+import (
+	"context"
+	"time"
+
+	"github.com/criyle/go-judge/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MaxRetries is the maximum number of retries of a task on transient failures.
+	MaxRetries = 3
+
+	// RetryBaseDelay is the delay before the first retry, it doubles for each retry.
+	RetryBaseDelay = 500 * time.Millisecond
+
+	// RetryMaxDelay is the maximum delay between two retries.
+	RetryMaxDelay = 8 * time.Second
+)
+
+// isTransientError returns true if the error of an Exec RPC is transient,
+// which means the task may succeed if it is executed again.
+//
+// ctx is the context of the RPC.
+// A DeadlineExceeded error is transient only if it is not caused by the timeout of ctx,
+// like a failure to connect to the judge server.
+func isTransientError(ctx context.Context, err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	case codes.DeadlineExceeded:
+		return ctx.Err() == nil
+	default:
+		return false
+	}
+}
+
+// isTransientResult returns true if the response of an Exec RPC means that the judge server
+// failed to execute the task, rather than the task itself failed.
+func isTransientResult(res *pb.Response) bool {
+	if len(res.Results) == 0 {
+		return true
+	}
+	for _, r := range res.Results {
+		if r.Status == pb.Response_Result_JudgementFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay returns the delay before the n-th (0-based) retry.
+func retryDelay(n int) time.Duration {
+	delay := RetryBaseDelay << n
+	if delay > RetryMaxDelay || delay <= 0 {
+		return RetryMaxDelay
+	}
+	return delay
+}
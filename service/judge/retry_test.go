@@ -0,0 +1,77 @@
+package judge
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/criyle/go-judge/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyExecutor is an executor which fails with the given errors before it succeeds.
+type flakyExecutor struct {
+	pb.ExecutorClient
+
+	errs  []error
+	calls int
+}
+
+func (e *flakyExecutor) Exec(
+	_ context.Context, _ *pb.Request, _ ...grpc.CallOption,
+) (*pb.Response, error) {
+	e.calls++
+	if len(e.errs) > 0 {
+		err := e.errs[0]
+		e.errs = e.errs[1:]
+		return nil, err
+	}
+	return &pb.Response{Results: []*pb.Response_Result{{Status: pb.Response_Result_Accepted}}}, nil
+}
+
+func runWithExecutor(e pb.ExecutorClient) (*pb.Response_Result, error) {
+	var (
+		result *pb.Response_Result
+		err    error
+	)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	task := DefaultTask().WithCallback(func(r *pb.Response_Result, e error) bool {
+		result, err = r, e
+		wg.Done()
+		return true
+	})
+	j := newJudge("test", e, 1)
+	j.start()
+	j.AddRequest(NewRequest(context.Background()).Execute(task))
+	wg.Wait()
+	return result, err
+}
+
+// TestRetryTransient tests that a task is retried on transient failures.
+func TestRetryTransient(t *testing.T) {
+	e := &flakyExecutor{errs: []error{status.Error(codes.Unavailable, "connection refused")}}
+	r, err := runWithExecutor(e)
+	if err != nil {
+		t.Fatalf("task should succeed after retry, but %v", err)
+	}
+	if r.Status != pb.Response_Result_Accepted {
+		t.Errorf("status should be Accepted, but %v", r.Status)
+	}
+	if e.calls != 2 {
+		t.Errorf("executor should be called 2 times, but %d", e.calls)
+	}
+}
+
+// TestRetryPermanent tests that a task is not retried on permanent failures.
+func TestRetryPermanent(t *testing.T) {
+	e := &flakyExecutor{errs: []error{status.Error(codes.InvalidArgument, "bad request")}}
+	if _, err := runWithExecutor(e); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("task should fail with InvalidArgument, but %v", err)
+	}
+	if e.calls != 1 {
+		t.Errorf("executor should be called 1 time, but %d", e.calls)
+	}
+}
@@ -0,0 +1,153 @@
+package judge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/criyle/go-judge/pb"
+)
+
+// ErrTaskStopped is the error passed to the callback of a task stopped by Task.Stop.
+var ErrTaskStopped = errors.New("task stopped")
+
+// StreamOutputFunction is called with the real-time output of a streaming task.
+//
+// name is "stdout" or "stderr".
+type StreamOutputFunction func(name string, content []byte)
+
+// streamName returns the name of a stream of the i-th command of a request.
+func streamName(i int, name string) string {
+	if i == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, i)
+}
+
+// streamOutput is a handler of a named output stream.
+type streamOutput struct {
+	name     string
+	onOutput StreamOutputFunction
+}
+
+// execStream executes a request once by the streaming API.
+//
+// Streaming tasks are never retried, as their input can not be replayed.
+func (j *Judge) execStream(
+	parentCtx context.Context, task *Task, pbr *pb.Request,
+) (*pb.Response, bool, error) {
+	ctx, cancel, err := taskContext(parentCtx, task)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cancel()
+
+	fail := func(err error) (*pb.Response, bool, error) {
+		if task.Stopped() {
+			return nil, false, ErrTaskStopped
+		}
+		return nil, false, err
+	}
+
+	stream, err := j.execClient.ExecStream(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	if err := stream.Send(&pb.StreamRequest{
+		Request: &pb.StreamRequest_ExecRequest{ExecRequest: pbr},
+	}); err != nil {
+		return fail(err)
+	}
+
+	// Send the real-time input, the messages of a stream can not be sent concurrently.
+	sendMu := sync.Mutex{}
+	outputs := make(map[string]streamOutput)
+	for i, c := range task.Commands() {
+		for _, name := range c.StreamOutputs {
+			outputs[streamName(i, name)] = streamOutput{name: name, onOutput: c.OnOutput}
+		}
+		if c.StdinStream == nil {
+			continue
+		}
+		go func(name string, in <-chan []byte) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case content, ok := <-in:
+					if !ok {
+						return
+					}
+					sendMu.Lock()
+					err := stream.Send(&pb.StreamRequest{
+						Request: &pb.StreamRequest_ExecInput{
+							ExecInput: &pb.StreamRequest_Input{Name: name, Content: content},
+						},
+					})
+					sendMu.Unlock()
+					if err != nil {
+						return
+					}
+				}
+			}
+		}(streamName(i, "stdin"), c.StdinStream)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return fail(err)
+		}
+		switch r := resp.Response.(type) {
+		case *pb.StreamResponse_ExecOutput:
+			if o, ok := outputs[r.ExecOutput.Name]; ok && o.onOutput != nil {
+				o.onOutput(o.name, r.ExecOutput.Content)
+			}
+		case *pb.StreamResponse_ExecResponse:
+			return r.ExecResponse, false, nil
+		}
+	}
+}
+
+// setCancel sets the cancel function of the running execution of the task.
+// Returns false if the task has been stopped.
+func (t *Task) setCancel(cancel context.CancelFunc) bool {
+	t.stopMu.Lock()
+	defer t.stopMu.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.cancel = cancel
+	return true
+}
+
+// Stop stops the task, the running execution of the task will be killed,
+// and the task will fail with ErrTaskStopped, without aborting the other tasks of its request.
+//
+// If the task has not started yet, it will never start.
+func (t *Task) Stop() {
+	t.stopMu.Lock()
+	defer t.stopMu.Unlock()
+	t.stopped = true
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// Stopped returns true if the task is stopped.
+func (t *Task) Stopped() bool {
+	t.stopMu.Lock()
+	defer t.stopMu.Unlock()
+	return t.stopped
+}
+
+// Streaming returns true if the task or its piped tasks need the streaming API.
+func (t *Task) Streaming() bool {
+	for _, c := range t.Commands() {
+		if c.StdinStream != nil || len(c.StreamOutputs) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package judge
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/criyle/go-judge/pb"
+	"google.golang.org/grpc"
+)
+
+// echoStream is a fake stream which echoes the stdin of a request to its stdout.
+type echoStream struct {
+	grpc.ClientStream
+
+	out chan *pb.StreamResponse
+}
+
+func (s *echoStream) Send(req *pb.StreamRequest) error {
+	switch r := req.Request.(type) {
+	case *pb.StreamRequest_ExecInput:
+		if len(r.ExecInput.Content) == 0 {
+			s.out <- &pb.StreamResponse{Response: &pb.StreamResponse_ExecResponse{
+				ExecResponse: &pb.Response{Results: []*pb.Response_Result{
+					{Status: pb.Response_Result_Accepted},
+				}},
+			}}
+			return nil
+		}
+		s.out <- &pb.StreamResponse{Response: &pb.StreamResponse_ExecOutput{
+			ExecOutput: &pb.StreamResponse_Output{Name: "stdout", Content: r.ExecInput.Content},
+		}}
+	}
+	return nil
+}
+
+func (s *echoStream) Recv() (*pb.StreamResponse, error) {
+	resp, ok := <-s.out
+	if !ok {
+		return nil, io.EOF
+	}
+	return resp, nil
+}
+
+// streamExecutor is an executor whose streams are echo streams.
+type streamExecutor struct {
+	pb.ExecutorClient
+}
+
+func (e *streamExecutor) ExecStream(
+	_ context.Context, _ ...grpc.CallOption,
+) (pb.Executor_ExecStreamClient, error) {
+	return &echoStream{out: make(chan *pb.StreamResponse, 16)}, nil
+}
+
+// TestStreamTask tests that the real-time input of a task is sent to the judge,
+// and the real-time output is sent to OnOutput.
+func TestStreamTask(t *testing.T) {
+	in := make(chan []byte)
+	outputs := []string{}
+	var (
+		result *pb.Response_Result
+		err    error
+	)
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	task := DefaultTask().
+		WithCmd("cat").
+		WithStdinStream(in).
+		WithOutputStream(func(name string, content []byte) {
+			outputs = append(outputs, name+":"+string(content))
+		}, "stdout").
+		WithCallback(func(r *pb.Response_Result, e error) bool {
+			result, err = r, e
+			wg.Done()
+			return true
+		})
+
+	req := task.ToPbRequest()
+	if req.Cmd[0].Files[0].GetStreamIn().GetName() != "stdin" {
+		t.Errorf("stdin should be streamed")
+	}
+	if req.Cmd[0].Files[1].GetStreamOut().GetName() != "stdout" {
+		t.Errorf("stdout should be streamed")
+	}
+	if len(req.Cmd[0].CopyOutCached) != 0 {
+		t.Errorf("streamed stdout should not be copied out")
+	}
+
+	j := newJudge("test", &streamExecutor{}, 1)
+	j.start()
+	j.AddRequest(NewRequest(context.Background()).Execute(task))
+	in <- []byte("hello")
+	in <- []byte{}
+	wg.Wait()
+
+	if err != nil {
+		t.Fatalf("task should succeed, but %v", err)
+	}
+	if result.Status != pb.Response_Result_Accepted {
+		t.Errorf("status should be Accepted, but %v", result.Status)
+	}
+	if len(outputs) != 1 || outputs[0] != "stdout:hello" {
+		t.Errorf("outputs should be [stdout:hello], but %v", outputs)
+	}
+}
+
+// TestStopTask tests that a stopped task fails with ErrTaskStopped without being executed,
+// and the other tasks of the request are not aborted.
+func TestStopTask(t *testing.T) {
+	e := &flakyExecutor{}
+	var err, nextErr error
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	task := DefaultTask().WithCallback(func(_ *pb.Response_Result, e error) bool {
+		err = e
+		wg.Done()
+		return true
+	})
+	task.Stop()
+	next := DefaultTask().WithCallback(func(_ *pb.Response_Result, e error) bool {
+		nextErr = e
+		wg.Done()
+		return true
+	})
+
+	j := newJudge("test", e, 1)
+	j.start()
+	j.AddRequest(NewRequest(context.Background()).Execute(task).Then(next))
+	wg.Wait()
+
+	if err != ErrTaskStopped {
+		t.Errorf("task should fail with ErrTaskStopped, but %v", err)
+	}
+	if nextErr != nil {
+		t.Errorf("next task should succeed, but %v", nextErr)
+	}
+	if e.calls != 1 {
+		t.Errorf("executor should be called once, but %d", e.calls)
+	}
+}
@@ -1,6 +1,11 @@
 package judge
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
 	"github.com/criyle/go-judge/pb"
 	"github.com/google/uuid"
 )
@@ -13,12 +18,37 @@ const (
 	DefaultStderrLimit = 10 * 1024              // 10 kB
 )
 
+// Kinds of tasks, which are used to classify the metrics of tasks.
+const (
+	TaskKindCompile  = "compile"
+	TaskKindGenerate = "generate"
+	TaskKindValidate = "validate"
+	TaskKindRun      = "run"
+	TaskKindCheck    = "check"
+)
+
+var (
+	// ErrInvalidPipe is returned when a pipe of a task refers to an unknown command or file
+	// descriptor.
+	ErrInvalidPipe = errors.New("invalid pipe")
+
+	// ErrInvalidStream is returned when a task streams an unknown output.
+	ErrInvalidStream = errors.New("invalid output stream")
+)
+
+// outputStreamFds are the file descriptors of the outputs which can be streamed.
+var outputStreamFds = map[string]int32{"stdout": 1, "stderr": 2}
+
 // DefaultEnv is the default environment variables.
 var DefaultEnv = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=/tmp"}
 
 // CallbackFunction is the callback function when a task is finished.
 // If the task is successful, the callback function will be called with the result.
 // If the task is failed, the callback function will be called with the error.
+// If the request of the task is cancelled, the callback function will be called with the error
+// of the request context, even if the task has never been executed.
+// If the request is aborted by another task, the callback function will be called with
+// ErrTaskAborted, so it is always called once.
 // Return true to continue, false to stop.
 type CallbackFunction func(*pb.Response_Result, error) bool
 
@@ -29,6 +59,9 @@ type Task struct {
 	// It is generated automatically.
 	ID uuid.UUID
 
+	// Kind is the kind of the task, like TaskKindCompile.
+	Kind string
+
 	// Cmd is the command to be executed.
 	Cmd []string
 
@@ -69,14 +102,55 @@ type Task struct {
 	// CopyOut is the files to be copied out.
 	CopyOut []string
 
+	// CopyOutMemory is the files to be copied out to the Files of the result,
+	// instead of the cache.
+	CopyOutMemory []string
+
 	// Callback is the callback function when a task is finished.
 	Callback CallbackFunction
+
+	// Piped are the tasks to be executed together with this task,
+	// their file descriptors can be connected by Pipes.
+	//
+	// Each piped task has its own limits, files and callback.
+	// The commands are indexed by their order: this task is 0, and Piped[i] is i+1.
+	// The callbacks of piped tasks are called before the callback of this task,
+	// so the callback of this task can make a decision with all the results.
+	Piped []*Task
+
+	// Pipes are the pipes between file descriptors of the commands.
+	Pipes []*pb.Request_PipeMap
+
+	// StdinStream is the real-time input of the task.
+	//
+	// If it is not nil, the task is executed by the streaming API,
+	// and the contents received from the channel are written to the stdin of the task.
+	StdinStream <-chan []byte
+
+	// StreamOutputs are the names of outputs ("stdout" or "stderr") to be sent to OnOutput
+	// in real time, instead of being collected.
+	//
+	// If it is not empty, the task is executed by the streaming API.
+	StreamOutputs []string
+
+	// OnOutput is called with the real-time outputs in StreamOutputs.
+	OnOutput StreamOutputFunction
+
+	// stopMu protects stopped and cancel.
+	stopMu sync.Mutex
+
+	// stopped is true if the task is stopped by Stop.
+	stopped bool
+
+	// cancel cancels the running execution of the task.
+	cancel context.CancelFunc
 }
 
 // DefaultTask returns a default (empty) task.
 func DefaultTask() *Task {
 	return &Task{
 		ID:          uuid.New(),
+		Kind:        TaskKindRun,
 		Cmd:         []string{},
 		TimeLimit:   DefaultTimeLimit,
 		MemoryLimit: DefaultMemoryLimit,
@@ -87,16 +161,28 @@ func DefaultTask() *Task {
 		Stdin: &pb.Request_File{
 			File: &pb.Request_File_Memory{Memory: &pb.Request_MemoryFile{Content: []byte{}}},
 		},
-		StdinCached:  nil,
-		CopyIn:       map[string]*pb.Request_File{},
-		CopyInCached: map[string]*string{},
-		CopyOut:      []string{},
+		StdinCached:   nil,
+		CopyIn:        map[string]*pb.Request_File{},
+		CopyInCached:  map[string]*string{},
+		CopyOut:       []string{},
+		CopyOutMemory: []string{},
 		Callback: func(*pb.Response_Result, error) bool {
 			return true
 		},
+		Piped:         []*Task{},
+		Pipes:         []*pb.Request_PipeMap{},
+		StdinStream:   nil,
+		StreamOutputs: []string{},
+		OnOutput:      nil,
 	}
 }
 
+// WithKind sets the kind of the task.
+func (t *Task) WithKind(kind string) *Task {
+	t.Kind = kind
+	return t
+}
+
 // WithCmd appends the command to be executed.
 func (t *Task) WithCmd(cmd ...string) *Task {
 	t.Cmd = append(t.Cmd, cmd...)
@@ -161,6 +247,14 @@ func (t *Task) WithCopyIn(path string, data []byte) *Task {
 	return t
 }
 
+// WithCopyInFiles adds the files to be copied in by their paths.
+func (t *Task) WithCopyInFiles(files map[string][]byte) *Task {
+	for path, data := range files {
+		t.WithCopyIn(path, data)
+	}
+	return t
+}
+
 // WithCopyInCached adds the files to be copied in from the cache.
 func (t *Task) WithCopyInCached(path string, fileID *string) *Task {
 	t.CopyInCached[path] = fileID
@@ -179,14 +273,127 @@ func (t *Task) WithCopyOut(paths ...string) *Task {
 	return t
 }
 
+// WithCopyOutMemory adds the files to be copied out to the Files of the result.
+func (t *Task) WithCopyOutMemory(paths ...string) *Task {
+	t.CopyOutMemory = append(t.CopyOutMemory, paths...)
+	return t
+}
+
 // WithCallback sets the callback function when a task is finished.
 func (t *Task) WithCallback(callback CallbackFunction) *Task {
 	t.Callback = callback
 	return t
 }
 
-// ToPbRequest converts the task to a protobuf request.
+// WithPiped adds the tasks to be executed together with this task.
+func (t *Task) WithPiped(tasks ...*Task) *Task {
+	t.Piped = append(t.Piped, tasks...)
+	return t
+}
+
+// WithPipe connects the file descriptor fromFd of the command from
+// to the file descriptor toFd of the command to.
+//
+// The index of this task is 0, and the index of Piped[i] is i+1.
+// For example, WithPipe(0, 1, 1, 0) connects the stdout of this task to the stdin of Piped[0].
+func (t *Task) WithPipe(from int, fromFd int, to int, toFd int) *Task {
+	t.Pipes = append(t.Pipes, &pb.Request_PipeMap{
+		In:  &pb.Request_PipeMap_PipeIndex{Index: int32(from), Fd: int32(fromFd)},
+		Out: &pb.Request_PipeMap_PipeIndex{Index: int32(to), Fd: int32(toFd)},
+	})
+	return t
+}
+
+// WithStdinStream sets the real-time input of the task.
+func (t *Task) WithStdinStream(in <-chan []byte) *Task {
+	t.StdinStream = in
+	return t
+}
+
+// WithOutputStream sends the outputs with given names ("stdout" or "stderr") to onOutput
+// in real time, instead of collecting them.
+func (t *Task) WithOutputStream(onOutput StreamOutputFunction, names ...string) *Task {
+	t.OnOutput = onOutput
+	t.StreamOutputs = append(t.StreamOutputs, names...)
+	return t
+}
+
+// Commands returns this task and its piped tasks, ordered by their indexes.
+func (t *Task) Commands() []*Task {
+	return append([]*Task{t}, t.Piped...)
+}
+
+// fail calls the callbacks of the task and its piped tasks with the error.
+func (t *Task) fail(err error) {
+	for _, p := range t.Piped {
+		p.Callback(nil, err)
+	}
+	t.Callback(nil, err)
+}
+
+// validPipe returns true if both ends of the pipe refer to one of the n commands,
+// and a non-negative file descriptor.
+func validPipe(p *pb.Request_PipeMap, n int) bool {
+	for _, end := range []*pb.Request_PipeMap_PipeIndex{p.In, p.Out} {
+		if end == nil || end.Index < 0 || int(end.Index) >= n || end.Fd < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validate returns ErrInvalidPipe if any pipe of the task is invalid, see validPipe,
+// or ErrInvalidStream if it or its piped tasks stream an unknown output.
+func (t *Task) validate() error {
+	cmds := t.Commands()
+	for _, p := range t.Pipes {
+		if !validPipe(p, len(cmds)) {
+			return fmt.Errorf("%w: %v", ErrInvalidPipe, p)
+		}
+	}
+	for _, c := range cmds {
+		for _, name := range c.StreamOutputs {
+			if _, ok := outputStreamFds[name]; !ok {
+				return fmt.Errorf("%w: '%s'", ErrInvalidStream, name)
+			}
+		}
+	}
+	return nil
+}
+
+// ToPbRequest converts the task and its piped tasks to a protobuf request.
+//
+// The task should be valid, see validate. Invalid pipes and output streams are ignored.
 func (t *Task) ToPbRequest() *pb.Request {
+	cmds := t.Commands()
+	piped := make([]map[int32]bool, len(cmds))
+	for i := range piped {
+		piped[i] = map[int32]bool{}
+	}
+	pipes := []*pb.Request_PipeMap{}
+	for _, p := range t.Pipes {
+		if !validPipe(p, len(cmds)) {
+			continue
+		}
+		pipes = append(pipes, p)
+		piped[p.In.Index][p.In.Fd] = true
+		piped[p.Out.Index][p.Out.Fd] = true
+	}
+	req := &pb.Request{
+		Cmd:         make([]*pb.Request_CmdType, len(cmds)),
+		PipeMapping: pipes,
+	}
+	for i, c := range cmds {
+		req.Cmd[i] = c.toPbCmd(i, piped[i])
+	}
+	return req
+}
+
+// toPbCmd converts the task to the index-th protobuf command of a request.
+//
+// piped is the set of file descriptors connected by pipes,
+// which will not be collected as stdout or stderr.
+func (t *Task) toPbCmd(index int, piped map[int32]bool) *pb.Request_CmdType {
 	stdin := t.Stdin
 	if t.StdinCached != nil {
 		stdin = &pb.Request_File{
@@ -212,39 +419,70 @@ func (t *Task) ToPbRequest() *pb.Request {
 			},
 		}
 	}
-	req := &pb.Request{
-		Cmd: []*pb.Request_CmdType{
-			{
-				Args: t.Cmd,
-				Env:  t.Env,
-				Files: []*pb.Request_File{
-					stdin,
-					{
-						File: &pb.Request_File_Pipe{
-							Pipe: &pb.Request_PipeCollector{
-								Name: "stdout",
-								Max:  t.StdoutLimit,
-							},
-						},
-					},
-					{
-						File: &pb.Request_File_Pipe{
-							Pipe: &pb.Request_PipeCollector{
-								Name: "stderr",
-								Max:  t.StderrLimit,
-							},
-						},
-					},
+	files := []*pb.Request_File{
+		stdin,
+		{
+			File: &pb.Request_File_Pipe{
+				Pipe: &pb.Request_PipeCollector{
+					Name: "stdout",
+					Max:  t.StdoutLimit,
+				},
+			},
+		},
+		{
+			File: &pb.Request_File_Pipe{
+				Pipe: &pb.Request_PipeCollector{
+					Name: "stderr",
+					Max:  t.StderrLimit,
 				},
-				CpuTimeLimit:   t.TimeLimit,
-				ClockTimeLimit: t.TimeLimit * 2,
-				MemoryLimit:    t.MemoryLimit,
-				ProcLimit:      t.ProcLimit,
-				CopyIn:         copyIn,
-				CopyOut:        []*pb.Request_CmdCopyOutFile{{Name: "stderr"}},
-				CopyOutCached:  append([]*pb.Request_CmdCopyOutFile{{Name: "stdout"}}, appendedCopyOut...),
 			},
 		},
 	}
-	return req
+	streamed := make(map[int32]bool)
+	if t.StdinStream != nil {
+		streamed[0] = true
+		files[0] = &pb.Request_File{File: &pb.Request_File_StreamIn{
+			StreamIn: &pb.Request_StreamInput{Name: streamName(index, "stdin")},
+		}}
+	}
+	for _, name := range t.StreamOutputs {
+		fd, ok := outputStreamFds[name]
+		if !ok {
+			continue
+		}
+		streamed[fd] = true
+		files[fd] = &pb.Request_File{File: &pb.Request_File_StreamOut{
+			StreamOut: &pb.Request_StreamOutput{Name: streamName(index, name)},
+		}}
+	}
+	copyOut := []*pb.Request_CmdCopyOutFile{}
+	copyOutCached := []*pb.Request_CmdCopyOutFile{}
+	if !piped[1] && !streamed[1] {
+		copyOutCached = append(copyOutCached, &pb.Request_CmdCopyOutFile{Name: "stdout"})
+	}
+	if !piped[2] && !streamed[2] {
+		copyOut = append(copyOut, &pb.Request_CmdCopyOutFile{Name: "stderr"})
+	}
+	for _, f := range t.CopyOutMemory {
+		copyOut = append(copyOut, &pb.Request_CmdCopyOutFile{Name: f})
+	}
+	for fd := range piped {
+		for int(fd) >= len(files) {
+			files = append(files, &pb.Request_File{})
+		}
+		// An empty file means the file descriptor is connected by a pipe.
+		files[fd] = &pb.Request_File{}
+	}
+	return &pb.Request_CmdType{
+		Args:           t.Cmd,
+		Env:            t.Env,
+		Files:          files,
+		CpuTimeLimit:   t.TimeLimit,
+		ClockTimeLimit: t.TimeLimit * 2,
+		MemoryLimit:    t.MemoryLimit,
+		ProcLimit:      t.ProcLimit,
+		CopyIn:         copyIn,
+		CopyOut:        copyOut,
+		CopyOutCached:  append(copyOutCached, appendedCopyOut...),
+	}
 }
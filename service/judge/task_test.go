@@ -0,0 +1,111 @@
+package judge
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/criyle/go-judge/pb"
+)
+
+// TestPipedTaskToPbRequest tests an interactive task, whose solution and interactor
+// are connected by two pipes.
+func TestPipedTaskToPbRequest(t *testing.T) {
+	interactor := DefaultTask().WithCmd("interactor")
+	task := DefaultTask().
+		WithCmd("sol").
+		WithPiped(interactor).
+		WithPipe(0, 1, 1, 0). // sol stdout -> interactor stdin
+		WithPipe(1, 1, 0, 0)  // interactor stdout -> sol stdin
+
+	req := task.ToPbRequest()
+	if len(req.Cmd) != 2 {
+		t.Fatalf("request should have 2 commands, but %d", len(req.Cmd))
+	}
+	if len(req.PipeMapping) != 2 {
+		t.Fatalf("request should have 2 pipes, but %d", len(req.PipeMapping))
+	}
+
+	for i, cmd := range req.Cmd {
+		if cmd.Files[0].File != nil || cmd.Files[1].File != nil {
+			t.Errorf("stdin and stdout of command %d should be piped", i)
+		}
+		if cmd.Files[2].GetPipe().GetName() != "stderr" {
+			t.Errorf("stderr of command %d should be collected", i)
+		}
+		for _, f := range cmd.CopyOutCached {
+			if f.Name == "stdout" {
+				t.Errorf("stdout of command %d should not be copied out", i)
+			}
+		}
+	}
+}
+
+// TestPipedTaskCallbackOrder tests that the callbacks of piped tasks are called
+// before the callback of the main task.
+func TestPipedTaskCallbackOrder(t *testing.T) {
+	order := []string{}
+	task := DefaultTask().
+		WithCallback(func(*pb.Response_Result, error) bool {
+			order = append(order, "main")
+			return true
+		}).
+		WithPiped(DefaultTask().WithCallback(func(*pb.Response_Result, error) bool {
+			order = append(order, "piped")
+			return true
+		}))
+
+	task.fail(nil)
+	if len(order) != 2 || order[0] != "piped" || order[1] != "main" {
+		t.Errorf("callbacks should be called in order [piped main], but %v", order)
+	}
+}
+
+// TestTaskValidatePipes tests that pipes to unknown commands or negative file descriptors
+// are invalid.
+func TestTaskValidatePipes(t *testing.T) {
+	valid := DefaultTask().WithPiped(DefaultTask()).WithPipe(0, 1, 1, 0)
+	if err := valid.validate(); err != nil {
+		t.Errorf("pipe should be valid, got %s", err)
+	}
+
+	for _, task := range []*Task{
+		DefaultTask().WithPipe(0, 1, 1, 0),
+		DefaultTask().WithPiped(DefaultTask()).WithPipe(-1, 1, 1, 0),
+		DefaultTask().WithPiped(DefaultTask()).WithPipe(0, -1, 1, 0),
+		DefaultTask().WithPiped(DefaultTask()).WithPipe(0, 1, 2, 0),
+	} {
+		if err := task.validate(); !errors.Is(err, ErrInvalidPipe) {
+			t.Errorf("pipe %v should be invalid, got %v", task.Pipes[0], err)
+		}
+		// Invalid pipes are not sent to the judge.
+		if req := task.ToPbRequest(); len(req.PipeMapping) != 0 {
+			t.Errorf("invalid pipe %v should be ignored", task.Pipes[0])
+		}
+	}
+}
+
+// TestTaskValidateStreams tests that only stdout and stderr can be streamed.
+func TestTaskValidateStreams(t *testing.T) {
+	onOutput := func(string, []byte) {}
+	valid := DefaultTask().WithOutputStream(onOutput, "stdout", "stderr")
+	if err := valid.validate(); err != nil {
+		t.Errorf("streams should be valid, got %s", err)
+	}
+
+	for _, task := range []*Task{
+		DefaultTask().WithOutputStream(onOutput, "stdin"),
+		DefaultTask().WithPiped(DefaultTask().WithOutputStream(onOutput, "output.txt")),
+	} {
+		if err := task.validate(); !errors.Is(err, ErrInvalidStream) {
+			t.Errorf("stream of %v should be invalid, got %v", task.Commands(), err)
+		}
+		// Invalid streams are not sent to the judge.
+		for _, cmd := range task.ToPbRequest().Cmd {
+			for _, f := range cmd.Files {
+				if f.GetStreamOut() != nil {
+					t.Errorf("invalid stream should be ignored, got %v", f)
+				}
+			}
+		}
+	}
+}
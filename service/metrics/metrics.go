@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace is the namespace of all RinDAG metrics.
+const namespace = "rindag"
+
+var (
+	// JudgeInFlight is the number of tasks being executed by each judge.
+	JudgeInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "judge",
+		Name:      "in_flight_tasks",
+		Help:      "Number of tasks being executed by the judge.",
+	}, []string{"judge"})
+
+	// JudgeQueueLength is the number of tasks waiting to be executed by each judge.
+	JudgeQueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "judge",
+		Name:      "queue_length",
+		Help:      "Number of tasks waiting to be executed by the judge.",
+	}, []string{"judge"})
+
+	// TaskDuration is the duration of tasks by their kind, including retries.
+	TaskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "judge",
+		Name:      "task_duration_seconds",
+		Help:      "Duration of judge tasks, including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"kind"})
+
+	// Verdicts is the number of task results by the kind of tasks and the status of results.
+	Verdicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "judge",
+		Name:      "verdicts_total",
+		Help:      "Number of judge task results by status.",
+	}, []string{"kind", "status"})
+
+	// BuildPhaseDuration is the duration of each phase of problem builds.
+	BuildPhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "build",
+		Name:      "phase_duration_seconds",
+		Help:      "Duration of each phase of problem builds.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"phase"})
+
+	// StorageUploadBytes is the number of bytes uploaded to the object storage.
+	//
+	// It is not labeled by bucket, as each problem has its own bucket.
+	StorageUploadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "upload_bytes_total",
+		Help:      "Number of bytes uploaded to the object storage.",
+	})
+)
+
+// Handler returns the HTTP handler exposing all registered metrics,
+// including the gRPC client metrics of judges.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
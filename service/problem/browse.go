@@ -0,0 +1,130 @@
+package problem
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// TreeEntry is an entry of a directory in the repository of a problem.
+type TreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+
+	// Type is "tree" for a directory, otherwise "blob".
+	Type string `json:"type"`
+
+	// Size is the size of a blob, it is zero for a directory.
+	Size int64 `json:"size"`
+
+	Hash string `json:"hash"`
+}
+
+// Commit is a commit in the history of a problem.
+type Commit struct {
+	Hash    string    `json:"hash"`
+	Message string    `json:"message"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Time    time.Time `json:"time"`
+}
+
+// cleanPath cleans a path in the repository, the root is an empty string.
+func cleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// Tree returns the entries of the directory of the problem at rev.
+//
+// Returns object.ErrDirectoryNotFound if dir is not a directory.
+func (p *Problem) Tree(rev [20]byte, dir string) ([]TreeEntry, error) {
+	repo, err := p.Repo()
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := repo.CommitObject(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	dir = cleanPath(dir)
+	if dir != "" {
+		if tree, err = tree.Tree(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]TreeEntry, 0, len(tree.Entries))
+	for _, e := range tree.Entries {
+		entry := TreeEntry{
+			Name: e.Name,
+			Path: path.Join(dir, e.Name),
+			Type: "blob",
+			Hash: e.Hash.String(),
+		}
+		if e.Mode == filemode.Dir {
+			entry.Type = "tree"
+		} else if entry.Size, err = tree.Size(e.Name); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Log returns the history of the problem from rev, skipping offset commits and at most limit
+// commits.
+//
+// If filter is not empty, only the commits changing the file or the files in the directory are
+// returned, like "git log -- <filter>".
+func (p *Problem) Log(rev [20]byte, filter string, offset int, limit int) ([]Commit, error) {
+	repo, err := p.Repo()
+	if err != nil {
+		return nil, err
+	}
+
+	options := &gogit.LogOptions{From: plumbing.Hash(rev), Order: gogit.LogOrderCommitterTime}
+	if filter = cleanPath(filter); filter != "" {
+		options.PathFilter = func(p string) bool {
+			return p == filter || strings.HasPrefix(p, filter+"/")
+		}
+	}
+
+	iter, err := repo.Log(options)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	commits := []Commit{}
+	err = iter.ForEach(func(c *object.Commit) error {
+		if offset > 0 {
+			offset--
+			return nil
+		}
+		if len(commits) >= limit {
+			return storer.ErrStop
+		}
+		commits = append(commits, Commit{
+			Hash:    c.Hash.String(),
+			Message: c.Message,
+			Author:  c.Author.Name,
+			Email:   c.Author.Email,
+			Time:    c.Author.When,
+		})
+		return nil
+	})
+	return commits, err
+}
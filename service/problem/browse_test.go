@@ -0,0 +1,121 @@
+package problem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/google/uuid"
+)
+
+// testRepo creates a repository in memory with a commit for each map of files.
+func testRepo(t *testing.T, commits ...map[string]string) (*gogit.Repository, [][20]byte) {
+	repo, err := gogit.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := [][20]byte{}
+	for i, files := range commits {
+		for name, content := range files {
+			if err := util.WriteFile(w.Filesystem, name, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Add(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hash, err := w.Commit("commit", &gogit.CommitOptions{Author: &object.Signature{
+			Name:  "Test",
+			Email: "test@rindag.local",
+			When:  time.Unix(int64(i), 0),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return repo, hashes
+}
+
+// TestTree tests listing the directories of a problem.
+func TestTree(t *testing.T) {
+	repo, hashes := testRepo(t, map[string]string{"config.yaml": "", "sol/std.cpp": "std"})
+	p := NewProblem(uuid.New()).WithRepo(repo)
+
+	entries, err := p.Tree(hashes[0], "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+	for _, e := range entries {
+		if e.Name == "sol" && e.Type != "tree" {
+			t.Errorf("'sol' should be a tree, got %v", e)
+		}
+	}
+
+	entries, err = p.Tree(hashes[0], "sol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "sol/std.cpp" || entries[0].Size != 3 ||
+		entries[0].Type != "blob" {
+		t.Errorf("unexpected entries of 'sol': %v", entries)
+	}
+
+	if _, err := p.Tree(hashes[0], "config.yaml"); err != object.ErrDirectoryNotFound {
+		t.Errorf("a file should not be a directory, got %v", err)
+	}
+}
+
+// TestLog tests listing the history of a problem.
+func TestLog(t *testing.T) {
+	repo, hashes := testRepo(t,
+		map[string]string{"config.yaml": "1"},
+		map[string]string{"sol/std.cpp": "std"},
+		map[string]string{"config.yaml": "2"},
+	)
+	p := NewProblem(uuid.New()).WithRepo(repo)
+
+	commits, err := p.Log(hashes[2], "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 3 || commits[0].Author != "Test" {
+		t.Fatalf("unexpected commits: %v", commits)
+	}
+
+	commits, err = p.Log(hashes[2], "", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || commits[0].Hash != plumbing.Hash(hashes[1]).String() {
+		t.Errorf("unexpected page: %v", commits)
+	}
+
+	commits, err = p.Log(hashes[2], "sol", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 {
+		t.Errorf("expected 1 commit changing 'sol', got %v", commits)
+	}
+
+	commits, err = p.Log(hashes[2], "config.yaml", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 {
+		t.Errorf("expected 2 commits changing 'config.yaml', got %v", commits)
+	}
+}
@@ -8,8 +8,11 @@ import (
 	"io"
 	"io/ioutil"
 	"sync"
+	"time"
 
+	"rindag/service/etc"
 	"rindag/service/judge"
+	"rindag/service/metrics"
 
 	"github.com/criyle/go-judge/pb"
 	"github.com/go-git/go-billy/v5"
@@ -83,6 +86,9 @@ type CheckInfo struct {
 	// CheckerCompileResult is a compile result of checker.
 	CheckerCompileResult *RunResult `json:"checker_compile_result,omitempty"`
 
+	// ManagerCompileResult is a compile result of the manager of two-run problems.
+	ManagerCompileResult *RunResult `json:"manager_compile_result,omitempty"`
+
 	// JudgeResults is a map of test case id and the judge result.
 	JudgeResults map[string]map[string]*JudgeResult `json:"solution_run_results,omitempty"`
 
@@ -91,6 +97,12 @@ type CheckInfo struct {
 
 	// ExtraPassGroups are test groups that should not pass but actually pass.
 	ExtraPassGroups map[string][]string `json:"extra_pass_groups,omitempty"`
+
+	// Scores is a map of solution name to the scores of test groups.
+	Scores map[string]map[string]float64 `json:"scores,omitempty"`
+
+	// OutOfRangeGroups are test groups whose scores are not in the expected ranges.
+	OutOfRangeGroups map[string][]string `json:"out_of_range_groups,omitempty"`
 }
 
 // BuildInfo is a build information of a problem.
@@ -100,12 +112,34 @@ type BuildInfo struct {
 	Generate *GenerateInfo `json:"generate,omitempty"`
 	Validate *ValidateInfo `json:"validate,omitempty"`
 	Check    *CheckInfo    `json:"check,omitempty"`
+
+	// Duration is the time taken by the build.
+	Duration time.Duration `json:"duration"`
+}
+
+// FailedPhase returns the name of the phase failing the build, like "generate".
+//
+// Returns an empty string if the build is OK or has not finished any phase.
+func (b *BuildInfo) FailedPhase() string {
+	switch {
+	case b.OK:
+		return ""
+	case b.Parse != nil && !b.Parse.OK:
+		return "parse"
+	case b.Generate != nil && !b.Generate.OK:
+		return "generate"
+	case b.Validate != nil && !b.Validate.OK:
+		return "validate"
+	case b.Check != nil && !b.Check.OK:
+		return "check"
+	}
+	return ""
 }
 
 func (b *BuildInfo) Scan(value any) error {
 	bytes, ok := value.([]byte)
 	if !ok {
-		return errors.Errorf("Failed to unmarshal JSONB value:", value)
+		return errors.Errorf("Failed to unmarshal JSONB value: %v", value)
 	}
 
 	result := BuildInfo{}
@@ -144,15 +178,42 @@ func (p *Problem) BuildParse(rev [20]byte) *ParseInfo {
 		}
 	}
 
-	// Ensure checker is valid.
-	if _, err := commit.File(conf.Checker); err != nil {
-		// Checker is not found in repo.
-		// Ensure it is a built-in checker.
-		c := BuiltinChecker(conf.Checker)
-		if _, err := c.GetSource(); err != nil {
+	// Ensure problem type is valid.
+	if !conf.Type.Valid() {
+		return &ParseInfo{
+			OK:  false,
+			Err: fmt.Sprintf("invalid problem type '%s'", conf.Type),
+		}
+	}
+
+	// Ensure manager is valid for two-run problems.
+	if conf.Type == ProblemTypeTwoRun {
+		if _, err := commit.File(conf.Manager); err != nil {
 			return &ParseInfo{
 				OK:  false,
-				Err: fmt.Sprintf("checker '%s' is not found: %s", conf.Checker, err),
+				Err: fmt.Sprintf("manager '%s' is not found: %s", conf.Manager, err),
+			}
+		}
+	}
+
+	// Ensure checker is valid.
+	if !conf.CheckerProtocol.Valid() {
+		return &ParseInfo{
+			OK:  false,
+			Err: fmt.Sprintf("invalid checker protocol '%s'", conf.CheckerProtocol),
+		}
+	}
+	// The built-in diff needs no checker.
+	if conf.CheckerProtocol != CheckerDiff {
+		if _, err := commit.File(conf.Checker); err != nil {
+			// Checker is not found in repo.
+			// Ensure it is a built-in checker.
+			c := BuiltinChecker(conf.Checker)
+			if _, err := c.GetSource(); err != nil {
+				return &ParseInfo{
+					OK:  false,
+					Err: fmt.Sprintf("checker '%s' is not found: %s", conf.Checker, err),
+				}
 			}
 		}
 	}
@@ -194,6 +255,61 @@ func (p *Problem) BuildParse(rev [20]byte) *ParseInfo {
 		}
 	}
 
+	// Ensure graders are valid.
+	// The solutions in the repo are compiled with the grader of DefaultLanguage,
+	// and it is used by submissions of the language with the same name.
+	if _, ok := conf.Graders[DefaultLanguage]; len(conf.Graders) > 0 && !ok {
+		return &ParseInfo{
+			OK:  false,
+			Err: fmt.Sprintf("no grader of the language of solutions '%s'", DefaultLanguage),
+		}
+	}
+	for lang, g := range conf.Graders {
+		if _, ok := etc.Config.Languages[lang]; !ok {
+			return &ParseInfo{
+				OK:  false,
+				Err: fmt.Sprintf("grader of unknown language '%s'", lang),
+			}
+		}
+		for _, paths := range [][]string{g.Sources, g.Headers, g.Public} {
+			for _, pa := range paths {
+				if _, err := commit.File(pa); err != nil {
+					return &ParseInfo{
+						OK:  false,
+						Err: fmt.Sprintf("grader file '%s' of '%s' is not found: %s", pa, lang, err),
+					}
+				}
+			}
+		}
+	}
+
+	// Ensure testlib and includes are valid.
+	if _, err := p.GetHeaders(rev, conf); err != nil {
+		return &ParseInfo{
+			OK:  false,
+			Err: err.Error(),
+		}
+	}
+
+	// Ensure expected scores are valid.
+	for name, sol := range conf.Solutions {
+		for groupName, r := range sol.Scores {
+			if _, ok := conf.TestGroups[groupName]; !ok {
+				return &ParseInfo{
+					OK: false,
+					Err: fmt.Sprintf(
+						"solution '%s' has scores of '%s' but it is not found", name, groupName),
+				}
+			}
+			if r.Min > r.Max {
+				return &ParseInfo{
+					OK:  false,
+					Err: fmt.Sprintf("solution '%s' has invalid score range of '%s'", name, groupName),
+				}
+			}
+		}
+	}
+
 	// Ensure test cases are valid.
 	for groupName, g := range conf.TestGroups {
 		// Ensure depends are exist.
@@ -214,6 +330,14 @@ func (p *Problem) BuildParse(rev [20]byte) *ParseInfo {
 			}
 		}
 
+		// Ensure scoring mode is valid.
+		if !g.Scoring.Valid() {
+			return &ParseInfo{
+				OK:  false,
+				Err: fmt.Sprintf("test group '%s' has invalid scoring mode '%s'", groupName, g.Scoring),
+			}
+		}
+
 		// Ensure test cases are valid.
 		for i, t := range g.Tests {
 			// A test case is either a fixed test or a generated test.
@@ -263,8 +387,11 @@ func getTestCasePathPrefix(group string, idx int) string {
 // 3. For all test data, if its output is fixed, copy it from problem repo;
 //    otherwise, use the standard solution to generate it.
 // 4. Create a memory file system with the input data.
+//
+// For output-only problems, the standard solution is not compiled,
+// and the answers are copied from its output files.
 func (p *Problem) BuildGenerate(
-	rev [20]byte, conf *Config, fs billy.Filesystem,
+	ctx context.Context, rev [20]byte, conf *Config, fs billy.Filesystem,
 ) *GenerateInfo {
 	type compileResponse struct {
 		Name   string
@@ -277,6 +404,14 @@ func (p *Problem) BuildGenerate(
 		FileID string
 	}
 
+	headers, err := p.GetHeaders(rev, conf)
+	if err != nil {
+		return &GenerateInfo{
+			OK:  false,
+			Err: fmt.Sprintf("failed to get headers: %s", err),
+		}
+	}
+
 	generators := make(map[string]*Generator)
 
 	generatorCompileTasks := []*judge.Task{}
@@ -284,7 +419,7 @@ func (p *Problem) BuildGenerate(
 	generatorCompileWG := &sync.WaitGroup{}
 
 	for name, path := range conf.Generators {
-		g := NewGeneratorFromProblem(p, rev, path)
+		g := NewGeneratorFromProblem(p, rev, path).WithHeaders(headers)
 		generators[name] = g
 
 		// Use closure to pass the generator name.
@@ -316,18 +451,38 @@ func (p *Problem) BuildGenerate(
 		close(generatorCompileResponses)
 	}()
 
-	std := NewSolutionFromProblem(p, rev, conf.Solutions[conf.StandardSolution].Path)
-	stdCompileResponses := make(chan *RunResult, 1)
-	stdCompileTask, err := std.CompileTask(func(r *pb.Response_Result, err error) bool {
-		result := ParseRunResult(r, err)
-		stdCompileResponses <- result
-		if !result.Finished {
-			return false
+	outputOnly := conf.Type == ProblemTypeOutputOnly
+
+	grader, err := p.GetGrader(rev, conf, DefaultLanguage)
+	if err != nil {
+		return &GenerateInfo{
+			OK:  false,
+			Err: fmt.Sprintf("failed to get grader: %s", err),
 		}
-		return true
-	})
+	}
 
-	defer close(stdCompileResponses)
+	std := NewSolutionFromProblem(p, rev, conf.Solutions[conf.StandardSolution].Path).
+		WithGrader(grader).
+		WithHeaders(headers)
+	stdCompileResponses := make(chan *RunResult, 1)
+	stdCompileTasks := []*judge.Task{}
+	if !outputOnly {
+		stdCompileTask, err := std.CompileTask(func(r *pb.Response_Result, err error) bool {
+			result := ParseRunResult(r, err)
+			stdCompileResponses <- result
+			if !result.Finished {
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return &GenerateInfo{
+				OK:  false,
+				Err: fmt.Sprintf("failed to get compile task for standard solution: %s", err),
+			}
+		}
+		stdCompileTasks = append(stdCompileTasks, stdCompileTask)
+	}
 
 	generateTasks := []*judge.Task{}
 	generateResponses := make(chan generateRunResponse, 16)
@@ -344,6 +499,7 @@ func (p *Problem) BuildGenerate(
 		info.TestGroups[groupName] = &TestGroup{
 			Depends:     group.Depends,
 			FullScore:   group.FullScore,
+			Scoring:     group.Scoring,
 			TimeLimit:   group.TimeLimit,
 			MemoryLimit: group.MemoryLimit,
 			Tests:       []TestCase{},
@@ -411,10 +567,10 @@ func (p *Problem) BuildGenerate(
 						func(r *pb.Response_Result, err error) bool {
 							result := ParseRunResult(r, err)
 							inf = pb.Request_File{File: &pb.Request_File_Cached{
-								Cached: &pb.Request_CachedFile{FileID: r.FileIDs["stdout"]},
+								Cached: &pb.Request_CachedFile{FileID: r.GetFileIDs()["stdout"]},
 							}}
 							generateResponses <- generateRunResponse{
-								Path: infPath, Result: result, FileID: r.FileIDs["stdout"],
+								Path: infPath, Result: result, FileID: r.GetFileIDs()["stdout"],
 							}
 							generateWG.Done()
 							if !result.Finished {
@@ -461,6 +617,34 @@ func (p *Problem) BuildGenerate(
 				}
 
 				testCase.AnsFrom = []string{conf.FixedTests[test.Fixed].Ans}
+			} else if outputOnly {
+				// Answer from the output of the standard solution.
+				outPath := OutputPath(conf.Solutions[conf.StandardSolution].Path, prefix)
+
+				memFile, err := fs.Create(ansPath)
+				if err != nil {
+					return &GenerateInfo{
+						OK:  false,
+						Err: fmt.Sprintf("failed to create answer '%s': %s", ansPath, err),
+					}
+				}
+
+				source, err := p.File(rev, outPath)
+				if err != nil {
+					return &GenerateInfo{
+						OK:  false,
+						Err: fmt.Sprintf("failed to get standard output '%s': %s", outPath, err),
+					}
+				}
+
+				if _, err := io.Copy(memFile, source); err != nil {
+					return &GenerateInfo{
+						OK:  false,
+						Err: fmt.Sprintf("failed to copy standard output '%s': %s", outPath, err),
+					}
+				}
+
+				testCase.AnsFrom = []string{outPath}
 			} else {
 				// Generated answer.
 				task := func(ansPath string) *judge.Task {
@@ -472,7 +656,7 @@ func (p *Problem) BuildGenerate(
 						func(r *pb.Response_Result, err error) bool {
 							result := ParseRunResult(r, err)
 							stdRunResponses <- generateRunResponse{
-								Path: ansPath, Result: result, FileID: r.FileIDs["stdout"],
+								Path: ansPath, Result: result, FileID: r.GetFileIDs()["stdout"],
 							}
 							stdRunWG.Done()
 							if !result.Finished {
@@ -510,12 +694,25 @@ func (p *Problem) BuildGenerate(
 		}
 	}
 
-	j.AddRequest(judge.NewRequest(context.Background()).
+	j.AddRequest(judge.NewRequest(ctx).WithKey(p.ID.String()).
 		Execute(generatorCompileTasks...).
-		Execute(stdCompileTask).
+		Execute(stdCompileTasks...).
 		Then(generateTasks...).
 		Then(stdRunTasks...))
 
+	// The callbacks of all tasks are called even if it returns early, like when the build is
+	// cancelled, so the responses not received are drained to not block them.
+	defer func() {
+		go func() {
+			for range generatorCompileResponses {
+			}
+			for range generateResponses {
+			}
+			for range stdRunResponses {
+			}
+		}()
+	}()
+
 	info.GeneratorCompileResults = make(map[string]*RunResult)
 
 	for resp := range generatorCompileResponses {
@@ -532,11 +729,13 @@ func (p *Problem) BuildGenerate(
 		return info
 	}
 
-	info.StdCompileResult = <-stdCompileResponses
-	if !info.StdCompileResult.Finished {
-		info.OK = false
-		info.Err = fmt.Sprintf("failed to compile standard solution: %s", info.StdCompileResult.Err)
-		return info
+	if !outputOnly {
+		info.StdCompileResult = <-stdCompileResponses
+		if !info.StdCompileResult.Finished {
+			info.OK = false
+			info.Err = fmt.Sprintf("failed to compile standard solution: %s", info.StdCompileResult.Err)
+			return info
+		}
 	}
 
 	info.GenerateResults = make(map[string]*RunResult)
@@ -560,7 +759,7 @@ func (p *Problem) BuildGenerate(
 			break
 		}
 
-		infContent, err := j.FileGet(context.Background(), resp.FileID)
+		infContent, err := j.FileGet(ctx, resp.FileID)
 		if err != nil {
 			info.OK = false
 			info.Err = fmt.Sprintf("failed to get input file '%s': %s", resp.Path, err)
@@ -599,7 +798,7 @@ func (p *Problem) BuildGenerate(
 			break
 		}
 
-		ansContent, err := j.FileGet(context.Background(), resp.FileID)
+		ansContent, err := j.FileGet(ctx, resp.FileID)
 		if err != nil {
 			info.OK = false
 			info.Err = fmt.Sprintf("failed to get answer file '%s': %s", resp.Path, err)
@@ -628,14 +827,23 @@ func (p *Problem) BuildGenerate(
 // 2. Run the validator at input files of all test cases.
 // 3. Return the result of the validation.
 func (p *Problem) BuildValidate(
-	rev [20]byte, conf *Config, testGroups map[string]*TestGroup, fs billy.Filesystem,
+	ctx context.Context, rev [20]byte, conf *Config, testGroups map[string]*TestGroup,
+	fs billy.Filesystem,
 ) *ValidateInfo {
 	type validateResponse struct {
 		Path   string
 		Result *RunResult
 	}
 
-	validator := NewValidatorFromProblem(p, rev, conf.Validator)
+	headers, err := p.GetHeaders(rev, conf)
+	if err != nil {
+		return &ValidateInfo{
+			OK:  false,
+			Err: fmt.Sprintf("failed to get headers: %s", err),
+		}
+	}
+
+	validator := NewValidatorFromProblem(p, rev, conf.Validator).WithHeaders(headers)
 
 	compileResponses := make(chan *RunResult, 1)
 	compileTask, err := validator.CompileTask(func(r *pb.Response_Result, err error) bool {
@@ -653,8 +861,6 @@ func (p *Problem) BuildValidate(
 		}
 	}
 
-	defer close(compileResponses)
-
 	validateTasks := []*judge.Task{}
 	validateResponses := make(chan validateResponse, 16)
 	validateWG := &sync.WaitGroup{}
@@ -717,7 +923,17 @@ func (p *Problem) BuildValidate(
 		}
 	}
 
-	j.AddRequest(judge.NewRequest(context.Background()).Execute(compileTask).Then(validateTasks...))
+	j.AddRequest(judge.NewRequest(ctx).WithKey(p.ID.String()).
+		Execute(compileTask).
+		Then(validateTasks...))
+
+	// The responses not received are drained, see BuildGenerate.
+	defer func() {
+		go func() {
+			for range validateResponses {
+			}
+		}()
+	}()
 
 	info := &ValidateInfo{OK: true}
 
@@ -754,8 +970,12 @@ func (p *Problem) BuildValidate(
 // 2. Run the solutions at input files of all test cases, and record these output file ID.
 // 3. Run the checker at all test cases, and record the results.
 // 4. Check if all the solutions passed the test groups which they should pass.
+//
+// For output-only problems, the solutions are not compiled or executed,
+// and the checker runs on their output files directly.
 func (p *Problem) BuildCheck(
-	rev [20]byte, conf *Config, testGroups map[string]*TestGroup, fs billy.Filesystem,
+	ctx context.Context, rev [20]byte, conf *Config, testGroups map[string]*TestGroup,
+	fs billy.Filesystem,
 ) *CheckInfo {
 	type compileResponse struct {
 		Name   string
@@ -768,6 +988,9 @@ func (p *Problem) BuildCheck(
 		TestCase  string
 		Result    *RunResult
 		OufID     string
+
+		// Ouf is the output content if it is not cached in the judge.
+		Ouf []byte
 	}
 
 	type checkResponse struct {
@@ -775,6 +998,11 @@ func (p *Problem) BuildCheck(
 		TestGroup string
 		TestCase  string
 		Result    *RunResult
+
+		// Status, Score and Message are the verdict of the checker.
+		Status  pb.Response_Result_StatusType
+		Score   int64
+		Message string
 	}
 
 	solutions := make(map[string]*Solution)
@@ -783,8 +1011,30 @@ func (p *Problem) BuildCheck(
 	solutionCompileResponses := make(chan compileResponse, 16)
 	solutionCompileWG := &sync.WaitGroup{}
 
+	outputOnly := conf.Type == ProblemTypeOutputOnly
+
+	grader, err := p.GetGrader(rev, conf, DefaultLanguage)
+	if err != nil {
+		return &CheckInfo{
+			OK:  false,
+			Err: fmt.Sprintf("failed to get grader: %s", err),
+		}
+	}
+
+	headers, err := p.GetHeaders(rev, conf)
+	if err != nil {
+		return &CheckInfo{
+			OK:  false,
+			Err: fmt.Sprintf("failed to get headers: %s", err),
+		}
+	}
+
 	for name, solConf := range conf.Solutions {
-		s := NewSolutionFromProblem(p, rev, solConf.Path)
+		if outputOnly {
+			continue
+		}
+
+		s := NewSolutionFromProblem(p, rev, solConf.Path).WithGrader(grader).WithHeaders(headers)
 		solutions[name] = s
 
 		cTask, err := func(name string) (*judge.Task, error) {
@@ -814,34 +1064,49 @@ func (p *Problem) BuildCheck(
 		close(solutionCompileResponses)
 	}()
 
-	var checker *Checker
-	if _, err := p.File(rev, conf.Checker); err != nil {
-		// Use built-in checker.
-		checker = BuiltinChecker(conf.Checker)
-	} else {
-		checker = NewCheckerFromProblem(p, rev, conf.Checker)
-	}
+	checker := p.GetChecker(rev, conf).WithHeaders(headers)
 
+	checkerCompileTasks := []*judge.Task{}
 	checkerCompileResponses := make(chan *RunResult, 1)
 
-	checkerCompileTask, err := checker.CompileTask(func(r *pb.Response_Result, err error) bool {
-		result := ParseRunResult(r, err)
-		checkerCompileResponses <- result
-		if !result.Finished {
-			return false
-		}
-		return true
-	})
-	if err != nil {
-		return &CheckInfo{
-			OK:  false,
-			Err: fmt.Sprintf("failed to get compile task for checker '%s': %s", conf.Checker, err),
+	if checker.NeedCompile() {
+		checkerCompileTask, err := checker.CompileTask(func(r *pb.Response_Result, err error) bool {
+			result := ParseRunResult(r, err)
+			checkerCompileResponses <- result
+			if !result.Finished {
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return &CheckInfo{
+				OK:  false,
+				Err: fmt.Sprintf("failed to get compile task for checker '%s': %s", conf.Checker, err),
+			}
 		}
+		checkerCompileTasks = append(checkerCompileTasks, checkerCompileTask)
 	}
 
-	defer close(checkerCompileResponses)
+	var manager *Manager
+	managerCompileTasks := []*judge.Task{}
+	managerCompileResponses := make(chan *RunResult, 1)
+	if conf.Type == ProblemTypeTwoRun {
+		manager = NewManagerFromProblem(p, rev, conf.Manager).WithHeaders(headers)
+		managerCompileTask, err := manager.CompileTask(func(r *pb.Response_Result, err error) bool {
+			result := ParseRunResult(r, err)
+			managerCompileResponses <- result
+			return result.Finished
+		})
+		if err != nil {
+			return &CheckInfo{
+				OK:  false,
+				Err: fmt.Sprintf("failed to get compile task for manager '%s': %s", conf.Manager, err),
+			}
+		}
+		managerCompileTasks = append(managerCompileTasks, managerCompileTask)
+	}
 
-	runTasks := []*judge.Task{}
+	phases := runPhases{}
 	runResponses := make(chan runResponse, 16)
 	runWG := &sync.WaitGroup{}
 
@@ -869,18 +1134,46 @@ func (p *Problem) BuildCheck(
 				Memory: &pb.Request_MemoryFile{Content: infContent},
 			}}
 
-			for solName := range conf.Solutions {
+			for solName, solConf := range conf.Solutions {
+				if outputOnly {
+					// Read the output file instead of running the solution.
+					outPath := OutputPath(solConf.Path, test.Prefix)
+					resp := runResponse{
+						Solution:  solName,
+						TestGroup: groupName,
+						TestCase:  test.Prefix,
+						Result:    &RunResult{Finished: true, Status: pb.Response_Result_Accepted},
+					}
+					if out, err := p.File(rev, outPath); err != nil {
+						resp.Result = &RunResult{Finished: true, Status: pb.Response_Result_FileError}
+					} else {
+						resp.Ouf, err = io.ReadAll(out)
+						out.Close()
+						if err != nil {
+							resp.Result = &RunResult{Err: err, Status: pb.Response_Result_FileError}
+						}
+					}
+
+					runWG.Add(1)
+					go func() {
+						runResponses <- resp
+						runWG.Done()
+					}()
+					continue
+				}
+
 				solution := solutions[solName]
 
-				runTask := func(solName string, groupName string, test TestCase) *judge.Task {
-					return solution.RunTask(
+				tasks := func(solName string, groupName string, test TestCase) []*judge.Task {
+					return solution.RunTasks(
+						conf,
+						manager,
 						group.TimeLimit,
 						group.MemoryLimit,
 						inf,
-						[]string{},
 						func(r *pb.Response_Result, err error) bool {
 							result := ParseRunResult(r, err)
-							stdoutID := r.FileIDs["stdout"]
+							stdoutID := r.GetFileIDs()["stdout"]
 							runResponses <- runResponse{
 								Solution:  solName,
 								TestGroup: groupName,
@@ -894,7 +1187,7 @@ func (p *Problem) BuildCheck(
 				}(solName, groupName, test)
 
 				runWG.Add(1)
-				runTasks = append(runTasks, runTask)
+				phases.add(tasks...)
 			}
 		}
 	}
@@ -912,10 +1205,20 @@ func (p *Problem) BuildCheck(
 		}
 	}
 
-	j.AddRequest(judge.NewRequest(context.Background()).
+	j.AddRequest(phases.then(judge.NewRequest(ctx).WithKey(p.ID.String()).
 		Execute(solutionCompileTasks...).
-		Execute(checkerCompileTask).
-		Then(runTasks...))
+		Execute(checkerCompileTasks...).
+		Execute(managerCompileTasks...)))
+
+	// The responses not received are drained, see BuildGenerate.
+	defer func() {
+		go func() {
+			for range solutionCompileResponses {
+			}
+			for range runResponses {
+			}
+		}()
+	}()
 
 	info := &CheckInfo{OK: true}
 
@@ -935,12 +1238,24 @@ func (p *Problem) BuildCheck(
 		return info
 	}
 
-	info.CheckerCompileResult = <-checkerCompileResponses
+	if checker.NeedCompile() {
+		info.CheckerCompileResult = <-checkerCompileResponses
 
-	if !info.CheckerCompileResult.Finished {
-		info.OK = false
-		info.Err = fmt.Sprintf("failed to compile checker: %s", err)
-		return info
+		if !info.CheckerCompileResult.Finished {
+			info.OK = false
+			info.Err = fmt.Sprintf("failed to compile checker: %s", err)
+			return info
+		}
+	}
+
+	if manager != nil {
+		info.ManagerCompileResult = <-managerCompileResponses
+
+		if !info.ManagerCompileResult.Finished {
+			info.OK = false
+			info.Err = fmt.Sprintf("failed to compile manager: %s", info.ManagerCompileResult.Err)
+			return info
+		}
 	}
 
 	runResults := make(map[SolutionTestCasePair]*RunResult)
@@ -958,6 +1273,13 @@ func (p *Problem) BuildCheck(
 	}
 
 	for resp := range runResponses {
+		if err := resp.Result.Err; err != nil {
+			info.OK = false
+			info.Err = fmt.Sprintf("failed to run solution '%s' on test case '%s': %s",
+				resp.Solution, resp.TestCase, err)
+			break
+		}
+
 		key := SolutionTestCasePair{resp.Solution, resp.TestCase}
 		runResults[key] = resp.Result
 		oufIDs[key] = resp.OufID
@@ -976,11 +1298,14 @@ func (p *Problem) BuildCheck(
 			break
 		}
 
-		oufContent, err := j.FileGet(context.TODO(), resp.OufID)
-		if err != nil {
-			info.OK = false
-			info.Err = fmt.Sprintf("failed to get output file for test case '%s': %s", resp.TestCase, err)
-			break
+		oufContent := &pb.FileContent{Content: resp.Ouf}
+		if resp.OufID != "" {
+			oufContent, err = j.FileGet(ctx, resp.OufID)
+			if err != nil {
+				info.OK = false
+				info.Err = fmt.Sprintf("failed to get output file for test case '%s': %s", resp.TestCase, err)
+				break
+			}
 		}
 
 		ansPath := resp.TestCase + ".ans"
@@ -1010,19 +1335,33 @@ func (p *Problem) BuildCheck(
 			continue
 		}
 
+		if !checker.NeedCompile() {
+			// The built-in diff compares the output directly, without a check task.
+			judgeResult := info.JudgeResults[resp.Solution][resp.TestCase]
+			judgeResult.Status, judgeResult.Score, judgeResult.CheckerResult = DiffOutput(
+				oufContent.Content, ansContent, checker.Tolerance, TestFullScore)
+			if judgeResult.Status != pb.Response_Result_Accepted {
+				notPass[SolutionTestCasePair{resp.Solution, resp.TestGroup}] = true
+			}
+			continue
+		}
+
 		checkTask := func(resp runResponse) *judge.Task {
 			return checker.CheckTask(
 				&pb.Request_File{File: &pb.Request_File_Memory{Memory: &pb.Request_MemoryFile{Content: infContent}}},
-				&pb.Request_File{File: &pb.Request_File_Cached{Cached: &pb.Request_CachedFile{FileID: resp.OufID}}},
+				oufFile(resp.OufID, resp.Ouf),
 				&pb.Request_File{File: &pb.Request_File_Memory{Memory: &pb.Request_MemoryFile{Content: ansContent}}},
 				func(r *pb.Response_Result, err error) bool {
-					result := ParseRunResult(r, err)
-					checkResponses <- checkResponse{
+					cResp := checkResponse{
 						Solution:  resp.Solution,
 						TestGroup: resp.TestGroup,
 						TestCase:  resp.TestCase,
-						Result:    result,
+						Result:    ParseRunResult(r, err),
+					}
+					if err == nil {
+						cResp.Status, cResp.Score, cResp.Message = checker.ParseResult(r, TestFullScore)
 					}
+					checkResponses <- cResp
 					checkWG.Done()
 					return true
 				},
@@ -1042,20 +1381,20 @@ func (p *Problem) BuildCheck(
 		close(checkResponses)
 	}()
 
-	j.AddRequest(judge.NewRequest(context.Background()).Execute(checkTasks...))
+	j.AddRequest(judge.NewRequest(ctx).WithKey(p.ID.String()).Execute(checkTasks...))
 
 	for resp := range checkResponses {
 		if err := resp.Result.Err; err != nil {
 			info.OK = false
 			info.Err = fmt.Sprintf("failed to check test case '%s': %s", resp.TestCase, err)
-			break
+			// Keep receiving, so the callbacks of the other check tasks are not blocked.
+			continue
 		}
-		chkMsg := string(resp.Result.Stderr)
-		status, _, msg := ParseTestlibOutput(chkMsg, 100)
-		info.JudgeResults[resp.Solution][resp.TestCase].Status = status
-		info.JudgeResults[resp.Solution][resp.TestCase].CheckerResult = msg
+		info.JudgeResults[resp.Solution][resp.TestCase].Status = resp.Status
+		info.JudgeResults[resp.Solution][resp.TestCase].Score = resp.Score
+		info.JudgeResults[resp.Solution][resp.TestCase].CheckerResult = resp.Message
 
-		if status != pb.Response_Result_Accepted {
+		if resp.Status != pb.Response_Result_Accepted {
 			notPass[SolutionTestCasePair{resp.Solution, resp.TestGroup}] = true
 		}
 	}
@@ -1079,6 +1418,33 @@ func (p *Problem) BuildCheck(
 		}
 	}
 
+	// Calculate the scores of solutions, and check if they are in the expected ranges.
+	info.Scores = make(map[string]map[string]float64)
+	info.OutOfRangeGroups = make(map[string][]string)
+
+	for solName, sol := range conf.Solutions {
+		testScores := make(map[string]int64)
+		for test, r := range info.JudgeResults[solName] {
+			testScores[test] = r.Score
+		}
+		scores, err := GroupScores(testGroups, testScores)
+		if err != nil {
+			info.OK = false
+			info.Err = fmt.Sprintf("failed to calculate scores of solution '%s': %s", solName, err)
+			return info
+		}
+		info.Scores[solName] = scores
+
+		for groupName, r := range sol.Scores {
+			if !r.Contains(scores[groupName]) {
+				info.OK = false
+				info.Err = fmt.Sprintf("score %v of test group '%s' of solution '%s' is not in [%v, %v]",
+					scores[groupName], groupName, solName, r.Min, r.Max)
+				info.OutOfRangeGroups[solName] = append(info.OutOfRangeGroups[solName], groupName)
+			}
+		}
+	}
+
 	info.ExtraPassGroups = make(map[string][]string)
 
 	for stp := range notPass {
@@ -1096,7 +1462,9 @@ func (p *Problem) BuildCheck(
 }
 
 // Build builds problem.
-func (p *Problem) Build(rev [20]byte) (*BuildInfo, billy.Filesystem) {
+//
+// The build will be aborted when ctx is cancelled.
+func (p *Problem) Build(ctx context.Context, rev [20]byte) (*BuildInfo, billy.Filesystem) {
 	result := &BuildInfo{
 		OK:       false,
 		Parse:    nil,
@@ -1105,26 +1473,39 @@ func (p *Problem) Build(rev [20]byte) (*BuildInfo, billy.Filesystem) {
 		Check:    nil,
 	}
 
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	phaseStart := start
+	observePhase := func(phase string) {
+		metrics.BuildPhaseDuration.WithLabelValues(phase).Observe(time.Since(phaseStart).Seconds())
+		phaseStart = time.Now()
+	}
+
 	result.Parse = p.BuildParse(rev)
+	observePhase("parse")
 	log.Debugf("build parse: %v", result.Parse)
 	if !result.Parse.OK {
 		return result, nil
 	}
 
 	fs := memfs.New()
-	result.Generate = p.BuildGenerate(rev, result.Parse.Config, fs)
+	result.Generate = p.BuildGenerate(ctx, rev, result.Parse.Config, fs)
+	observePhase("generate")
 	log.Debugf("build generate: %v", result.Generate)
 	if !result.Generate.OK {
 		return result, nil
 	}
 
-	result.Validate = p.BuildValidate(rev, result.Parse.Config, result.Generate.TestGroups, fs)
+	result.Validate = p.BuildValidate(ctx, rev, result.Parse.Config, result.Generate.TestGroups, fs)
+	observePhase("validate")
 	log.Debugf("build validate: %v", result.Validate)
 	if !result.Validate.OK {
 		return result, nil
 	}
 
-	result.Check = p.BuildCheck(rev, result.Parse.Config, result.Generate.TestGroups, fs)
+	result.Check = p.BuildCheck(ctx, rev, result.Parse.Config, result.Generate.TestGroups, fs)
+	observePhase("check")
 	log.Debugf("build check: %v", result.Check)
 	if !result.Check.OK {
 		return result, nil
@@ -5,6 +5,8 @@ import (
 	"embed"
 	"io"
 	"path"
+	"strconv"
+	"strings"
 
 	"rindag/service/etc"
 	"rindag/service/judge"
@@ -13,6 +15,55 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// CheckerProtocol is the protocol between the judge and a checker.
+type CheckerProtocol string
+
+const (
+	// CheckerTestlib is a testlib checker (default).
+	//
+	// It runs as "checker input.txt output.txt answer.txt",
+	// and its stderr is parsed by ParseTestlibOutput.
+	CheckerTestlib CheckerProtocol = "testlib"
+
+	// CheckerKattis is a Kattis output validator.
+	//
+	// It runs as "checker input.txt answer.txt feedback/" with the output as stdin,
+	// exits with 42 if the output is accepted, or 43 if it is wrong.
+	// The message is written to "feedback/judgemessage.txt".
+	CheckerKattis CheckerProtocol = "kattis"
+
+	// CheckerDOMjudge is a DOMjudge compare program, which has the same interface as
+	// CheckerKattis.
+	CheckerDOMjudge CheckerProtocol = "domjudge"
+
+	// CheckerLemon is a Lemon special judge, compatible with the score file style of Cena.
+	//
+	// It runs as "checker input.txt output.txt answer.txt <full score> score.txt message.txt",
+	// and writes the score of the output to "score.txt" and the message to "message.txt".
+	CheckerLemon CheckerProtocol = "lemon"
+
+	// CheckerDiff is the built-in diff, which needs no compilation, see DiffOutput.
+	CheckerDiff CheckerProtocol = "diff"
+)
+
+const (
+	// kattisAccepted is the exit code of Kattis output validators for accepted outputs.
+	kattisAccepted = 42
+
+	// kattisWrongAnswer is the exit code of Kattis output validators for wrong outputs.
+	kattisWrongAnswer = 43
+)
+
+// Valid returns true if the protocol is a known checker protocol or empty.
+func (p CheckerProtocol) Valid() bool {
+	switch p {
+	case "", CheckerTestlib, CheckerKattis, CheckerDOMjudge, CheckerLemon, CheckerDiff:
+		return true
+	default:
+		return false
+	}
+}
+
 // Checker is a checker for special judge.
 type Checker struct {
 	// binaryID is the ID of the checker binary.
@@ -22,6 +73,15 @@ type Checker struct {
 
 	// GetSource is a function returns the source code ReadCloser of the checker.
 	GetSource func() (io.ReadCloser, error)
+
+	// Headers are the headers copied in when compiling the checker, by their file names.
+	Headers map[string][]byte
+
+	// Protocol is the protocol of the checker, CheckerTestlib if empty.
+	Protocol CheckerProtocol
+
+	// Tolerance is the error of floats allowed by CheckerDiff.
+	Tolerance float64
 }
 
 // NewChecker creates a checker.
@@ -29,9 +89,30 @@ func NewChecker(getSource func() (io.ReadCloser, error)) *Checker {
 	return &Checker{
 		binaryID:  new(string),
 		GetSource: getSource,
+		Headers:   defaultHeaders(),
+		Protocol:  CheckerTestlib,
 	}
 }
 
+// WithProtocol sets the protocol of the checker.
+func (c *Checker) WithProtocol(protocol CheckerProtocol) *Checker {
+	if protocol != "" {
+		c.Protocol = protocol
+	}
+	return c
+}
+
+// WithTolerance sets the error of floats allowed by CheckerDiff.
+func (c *Checker) WithTolerance(tolerance float64) *Checker {
+	c.Tolerance = tolerance
+	return c
+}
+
+// NeedCompile returns true if the checker should be compiled before checking.
+func (c *Checker) NeedCompile() bool {
+	return c.Protocol != CheckerDiff
+}
+
 //go:embed third_party/testlib/checkers/*.cpp
 var builtinCheckersFS embed.FS
 
@@ -53,6 +134,18 @@ func NewCheckerFromProblem(problem *Problem, rev [20]byte, path string) *Checker
 	return NewChecker(func() (io.ReadCloser, error) { return problem.File(rev, path) })
 }
 
+// GetChecker returns the checker of the problem in config.
+//
+// If the checker is a path of the problem at rev, the checker from that path is used.
+// Otherwise the built-in checker with the name is used.
+func (p *Problem) GetChecker(rev [20]byte, conf *Config) *Checker {
+	checker := NewCheckerFromProblem(p, rev, conf.Checker)
+	if _, err := p.File(rev, conf.Checker); err != nil {
+		checker = BuiltinChecker(conf.Checker)
+	}
+	return checker.WithProtocol(conf.CheckerProtocol).WithTolerance(conf.Tolerance)
+}
+
 // NewCheckerFromBytes creates a checker from the source code.
 func NewCheckerFromBytes(source []byte) *Checker {
 	return NewChecker(
@@ -64,6 +157,12 @@ func NewCheckerFromReadCloser(r io.ReadCloser) *Checker {
 	return NewChecker(func() (io.ReadCloser, error) { return r, nil })
 }
 
+// WithHeaders sets the headers of the checker, see Problem.GetHeaders.
+func (c *Checker) WithHeaders(headers map[string][]byte) *Checker {
+	c.Headers = headers
+	return c
+}
+
 // CompileTask returns the compile task of the checker.
 func (c *Checker) CompileTask(cb judge.CallbackFunction) (*judge.Task, error) {
 	conf := etc.Config
@@ -77,6 +176,7 @@ func (c *Checker) CompileTask(cb judge.CallbackFunction) (*judge.Task, error) {
 		return nil, err
 	}
 	return judge.DefaultTask().
+		WithKind(judge.TaskKindCompile).
 		WithCmd(conf.Compile.Cmd...).
 		WithCmd(conf.Checker.Compile.Args...).
 		WithCmd("checker.cpp", "-o", "checker").
@@ -84,7 +184,7 @@ func (c *Checker) CompileTask(cb judge.CallbackFunction) (*judge.Task, error) {
 		WithMemoryLimit(conf.Compile.MemoryLimit).
 		WithStderrLimit(conf.Compile.StderrLimit).
 		WithCopyIn("checker.cpp", code).
-		WithCopyIn("testlib.h", TestlibSource).
+		WithCopyInFiles(c.Headers).
 		WithCopyOut("checker").
 		WithCallback(func(r *pb.Response_Result, err error) bool {
 			if finished := err == nil && r.Status == pb.Response_Result_Accepted; finished {
@@ -99,19 +199,87 @@ func (c *Checker) CompileTask(cb judge.CallbackFunction) (*judge.Task, error) {
 }
 
 // CheckTask needs a checker binary file ID, an input file, and output file, and a standard answer.
-// Returns a judge task to run the checker.
+// Returns a judge task to run the checker by its protocol,
+// whose result should be parsed by ParseResult.
+//
+// CheckerDiff has no check task, use DiffOutput instead.
 func (c *Checker) CheckTask(inf *pb.Request_File, ouf *pb.Request_File, ans *pb.Request_File,
 	cb judge.CallbackFunction,
 ) *judge.Task {
 	conf := &etc.Config.Checker
-	return judge.DefaultTask().
-		WithCmd("checker", "input.txt", "output.txt", "answer.txt").
+	task := judge.DefaultTask().
+		WithKind(judge.TaskKindCheck).
 		WithTimeLimit(conf.Run.TimeLimit).
 		WithMemoryLimit(conf.Run.MemoryLimit).
 		WithStderrLimit(conf.Run.StderrLimit).
 		WithCopyInCached("checker", c.binaryID).
 		WithCopyInFile("input.txt", inf).
-		WithCopyInFile("output.txt", ouf).
 		WithCopyInFile("answer.txt", ans).
 		WithCallback(cb)
+
+	switch c.Protocol {
+	case CheckerKattis, CheckerDOMjudge:
+		// The feedback directory is created by copying in an empty message.
+		return task.
+			WithCmd("checker", "input.txt", "answer.txt", "feedback/").
+			WithStdinFile(ouf).
+			WithCopyIn("feedback/judgemessage.txt", []byte{}).
+			WithCopyOutMemory("feedback/judgemessage.txt")
+	case CheckerLemon:
+		return task.
+			WithCmd("checker", "input.txt", "output.txt", "answer.txt",
+				strconv.Itoa(TestFullScore), "score.txt", "message.txt").
+			WithCopyInFile("output.txt", ouf).
+			WithCopyIn("score.txt", []byte{}).
+			WithCopyIn("message.txt", []byte{}).
+			WithCopyOutMemory("score.txt", "message.txt")
+	default:
+		return task.
+			WithCmd("checker", "input.txt", "output.txt", "answer.txt").
+			WithCopyInFile("output.txt", ouf)
+	}
+}
+
+// ParseResult parses the result of a check task by the protocol of the checker.
+// Returns the status, the score out of fullScore and the message.
+//
+// A checker which does not follow its protocol is considered as JudgementFailed.
+func (c *Checker) ParseResult(r *pb.Response_Result, fullScore int64) (
+	pb.Response_Result_StatusType, int64, string,
+) {
+	switch c.Protocol {
+	case CheckerKattis, CheckerDOMjudge:
+		msg := TruncateMessage(string(r.Files["feedback/judgemessage.txt"]))
+		if r.Status != pb.Response_Result_Accepted &&
+			r.Status != pb.Response_Result_NonZeroExitStatus {
+			return pb.Response_Result_JudgementFailed, 0, msg
+		}
+		switch r.ExitStatus {
+		case kattisAccepted:
+			return pb.Response_Result_Accepted, fullScore, msg
+		case kattisWrongAnswer:
+			return pb.Response_Result_WrongAnswer, 0, msg
+		default:
+			return pb.Response_Result_JudgementFailed, 0, msg
+		}
+	case CheckerLemon:
+		msg := TruncateMessage(string(r.Files["message.txt"]))
+		if r.Status != pb.Response_Result_Accepted {
+			return pb.Response_Result_JudgementFailed, 0, msg
+		}
+		points, err := strconv.ParseFloat(strings.TrimSpace(string(r.Files["score.txt"])), 64)
+		if err != nil {
+			return pb.Response_Result_JudgementFailed, 0, msg
+		}
+		switch ratio := points / TestFullScore; {
+		case ratio >= 1:
+			return pb.Response_Result_Accepted, fullScore, msg
+		case ratio > 0:
+			return pb.Response_Result_PartiallyCorrect, int64(float64(fullScore) * ratio), msg
+		default:
+			return pb.Response_Result_WrongAnswer, 0, msg
+		}
+	default:
+		return ParseTestlibOutput(TruncateMessage(string(r.Files["stderr"])), fullScore)
+	}
 }
@@ -0,0 +1,170 @@
+package problem
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"rindag/service/git"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// FileAction is the action of a file operation in a commit.
+type FileAction string
+
+const (
+	// FileActionCreate creates a file, which should not exist.
+	FileActionCreate FileAction = "create"
+
+	// FileActionUpdate replaces the content of a file, which should exist.
+	FileActionUpdate FileAction = "update"
+
+	// FileActionDelete deletes a file, which should exist.
+	FileActionDelete FileAction = "delete"
+)
+
+var (
+	// ErrBranchMoved is returned when the branch to commit to is not at the base revision.
+	ErrBranchMoved = errors.New("branch moved")
+
+	// ErrInvalidFileOperation is returned when a file operation can not be applied.
+	ErrInvalidFileOperation = errors.New("invalid file operation")
+
+	// ErrCommitDeclined is returned by a CommitCheck declining the new commit.
+	ErrCommitDeclined = errors.New("commit declined")
+)
+
+// CommitCheck validates the new commit in the cloned repo before it is pushed, see Commit.
+// The commit is not pushed if it returns an error.
+type CommitCheck func(repo *gogit.Repository, hash [20]byte) error
+
+// FileOperation is an operation on a file in a commit.
+type FileOperation struct {
+	Action FileAction `json:"action"`
+	Path   string     `json:"path"`
+
+	// Content is the new content of the file, it is ignored by FileActionDelete.
+	Content string `json:"content"`
+}
+
+// apply applies the operation to the worktree and stages it.
+func (op *FileOperation) apply(w *gogit.Worktree) error {
+	path := cleanPath(op.Path)
+	if path == "" {
+		return fmt.Errorf("%w: empty path", ErrInvalidFileOperation)
+	}
+
+	exists := false
+	if info, err := w.Filesystem.Stat(path); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%w: '%s' is a directory", ErrInvalidFileOperation, path)
+		}
+		exists = true
+	}
+
+	switch op.Action {
+	case FileActionCreate, FileActionUpdate:
+		if op.Action == FileActionCreate && exists {
+			return fmt.Errorf("%w: '%s' already exists", ErrInvalidFileOperation, path)
+		}
+		if op.Action == FileActionUpdate && !exists {
+			return fmt.Errorf("%w: '%s' does not exist", ErrInvalidFileOperation, path)
+		}
+		if err := util.WriteFile(w.Filesystem, path, []byte(op.Content), 0o644); err != nil {
+			return err
+		}
+		_, err := w.Add(path)
+		return err
+	case FileActionDelete:
+		if !exists {
+			return fmt.Errorf("%w: '%s' does not exist", ErrInvalidFileOperation, path)
+		}
+		_, err := w.Remove(path)
+		return err
+	default:
+		return fmt.Errorf("%w: unknown action '%s'", ErrInvalidFileOperation, op.Action)
+	}
+}
+
+// Commit applies the file operations on the base revision, and commits them to the branch.
+// Returns the hash of the new commit.
+//
+// Like initRepo, the branch is cloned into memory, edited, and pushed back.
+// Returns ErrBranchMoved if the branch is not at base, or it moves before the push.
+// If check is not nil, the commit is pushed only if it passes the check.
+func (p *Problem) Commit(
+	branch plumbing.ReferenceName, base [20]byte, ops []FileOperation, message string,
+	author *object.Signature, check CommitCheck,
+) ([20]byte, error) {
+	var hash [20]byte
+
+	sRepo, err := p.Repo()
+	if err != nil {
+		return hash, err
+	}
+	ref, err := sRepo.Reference(branch, true)
+	if err != nil {
+		return hash, err
+	}
+	if ref.Hash() != base {
+		return hash, ErrBranchMoved
+	}
+
+	repoPath := git.GetRepoPath(p.ID.String())
+	repo, err := gogit.Clone(memory.NewStorage(), memfs.New(), &gogit.CloneOptions{
+		URL:           repoPath,
+		ReferenceName: branch,
+		SingleBranch:  true,
+	})
+	if err != nil {
+		return hash, err
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return hash, err
+	}
+	// The branch may move while cloning.
+	if err := w.Reset(&gogit.ResetOptions{
+		Commit: base,
+		Mode:   gogit.HardReset,
+	}); err != nil {
+		return hash, err
+	}
+
+	for _, op := range ops {
+		if err := op.apply(w); err != nil {
+			return hash, err
+		}
+	}
+
+	hash, err = w.Commit(message, &gogit.CommitOptions{Author: author})
+	if err != nil {
+		return hash, err
+	}
+	if check != nil {
+		if err := check(repo, hash); err != nil {
+			return hash, err
+		}
+	}
+
+	// The push is rejected if the branch is not an ancestor of the commit any more.
+	if err := repo.Push(&gogit.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", branch, branch))},
+	}); err != nil {
+		// go-git does not wrap the error of a non-fast-forward push.
+		if strings.HasPrefix(err.Error(), "non-fast-forward update") {
+			return hash, ErrBranchMoved
+		}
+		return hash, err
+	}
+
+	return hash, nil
+}
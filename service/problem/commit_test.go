@@ -0,0 +1,109 @@
+package problem
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"rindag/service/etc"
+	"rindag/service/git"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/uuid"
+)
+
+// TestCommit tests committing file operations to a problem.
+func TestCommit(t *testing.T) {
+	oldDir, oldWorktree := etc.Config.Git.RepoDir, etc.Config.Problem.InitialWorktree
+	etc.Config.Git.RepoDir = t.TempDir()
+	etc.Config.Problem.InitialWorktree = map[string]string{"config.yaml": "", "std.cpp": ""}
+	defer func() {
+		etc.Config.Git.RepoDir, etc.Config.Problem.InitialWorktree = oldDir, oldWorktree
+	}()
+
+	p := NewProblem(uuid.New())
+	repo, err := p.Repo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := repo.Reference(git.MainBranch, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := ref.Hash()
+	author := &object.Signature{Name: "Test", Email: "test@rindag.local", When: time.Now()}
+
+	hash, err := p.Commit(git.MainBranch, base, []FileOperation{
+		{Action: FileActionCreate, Path: "/gen/rand.cpp", Content: "rand"},
+		{Action: FileActionUpdate, Path: "config.yaml", Content: "name: test"},
+		{Action: FileActionDelete, Path: "std.cpp"},
+	}, "Edit files", author, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ref, err = repo.Reference(git.MainBranch, true); err != nil || ref.Hash() != hash {
+		t.Fatalf("main should be at the new commit, got %v, %v", ref, err)
+	}
+	file, err := p.File(hash, "gen/rand.cpp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if content, _ := io.ReadAll(file); string(content) != "rand" {
+		t.Errorf("unexpected content: %s", content)
+	}
+	if _, err := p.File(hash, "std.cpp"); err == nil {
+		t.Error("'std.cpp' should be deleted")
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Author.Name != "Test" || commit.NumParents() != 1 || commit.ParentHashes[0] != base {
+		t.Errorf("unexpected commit: %v", commit)
+	}
+
+	// The base is stale now.
+	if _, err := p.Commit(git.MainBranch, base, []FileOperation{
+		{Action: FileActionUpdate, Path: "config.yaml", Content: "name: stale"},
+	}, "Stale edit", author, nil); !errors.Is(err, ErrBranchMoved) {
+		t.Errorf("expected ErrBranchMoved, got %v", err)
+	}
+
+	for _, op := range []FileOperation{
+		{Action: FileActionCreate, Path: "config.yaml"},
+		{Action: FileActionUpdate, Path: "missing.txt"},
+		{Action: FileActionDelete, Path: "gen"},
+		{Action: "rename", Path: "config.yaml"},
+		{Action: FileActionCreate, Path: "/"},
+	} {
+		_, err := p.Commit(git.MainBranch, hash, []FileOperation{op}, "Invalid", author, nil)
+		if !errors.Is(err, ErrInvalidFileOperation) {
+			t.Errorf("%v should be invalid, got %v", op, err)
+		}
+	}
+	if ref, err = repo.Reference(git.MainBranch, true); err != nil ||
+		ref.Hash() != hash {
+		t.Errorf("invalid commits should not move main, got %v, %v", ref, err)
+	}
+
+	// The check reads the new commit before it is pushed.
+	_, err = p.Commit(git.MainBranch, hash, []FileOperation{
+		{Action: FileActionUpdate, Path: "config.yaml", Content: "name: declined"},
+	}, "Declined edit", author, func(repo *gogit.Repository, hash [20]byte) error {
+		if _, err := repo.CommitObject(hash); err != nil {
+			t.Errorf("the new commit should be checked: %s", err)
+		}
+		return ErrCommitDeclined
+	})
+	if !errors.Is(err, ErrCommitDeclined) {
+		t.Errorf("expected ErrCommitDeclined, got %v", err)
+	}
+	if ref, err = repo.Reference(git.MainBranch, true); err != nil ||
+		ref.Hash() != hash {
+		t.Errorf("declined commits should not move main, got %v, %v", ref, err)
+	}
+}
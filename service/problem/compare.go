@@ -0,0 +1,333 @@
+package problem
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/criyle/go-judge/pb"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+)
+
+// FileDiff is the diff of a file between two revisions of a problem.
+type FileDiff struct {
+	// From is the path of the file in the old revision, it is empty if the file is added.
+	From string `json:"from"`
+
+	// To is the path of the file in the new revision, it is empty if the file is deleted.
+	To string `json:"to"`
+
+	Binary    bool `json:"binary"`
+	Additions int  `json:"additions"`
+	Deletions int  `json:"deletions"`
+
+	// Patch is the unified diff of the file.
+	Patch string `json:"patch"`
+}
+
+// filePatch is a patch of a single file, to encode file patches separately.
+type filePatch struct {
+	diff.FilePatch
+}
+
+func (p filePatch) FilePatches() []diff.FilePatch { return []diff.FilePatch{p.FilePatch} }
+
+func (p filePatch) Message() string { return "" }
+
+// DiffFiles returns the diffs of the changed files from the revision from to the revision to.
+func (p *Problem) DiffFiles(from [20]byte, to [20]byte) ([]FileDiff, error) {
+	repo, err := p.Repo()
+	if err != nil {
+		return nil, err
+	}
+
+	fromCommit, err := repo.CommitObject(from)
+	if err != nil {
+		return nil, err
+	}
+	toCommit, err := repo.CommitObject(to)
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := fromCommit.Patch(toCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := []FileDiff{}
+	for _, fp := range patch.FilePatches() {
+		d := FileDiff{Binary: fp.IsBinary()}
+		fromFile, toFile := fp.Files()
+		if fromFile != nil {
+			d.From = fromFile.Path()
+		}
+		if toFile != nil {
+			d.To = toFile.Path()
+		}
+		for _, chunk := range fp.Chunks() {
+			lines := strings.Count(chunk.Content(), "\n")
+			if !strings.HasSuffix(chunk.Content(), "\n") {
+				lines++
+			}
+			switch chunk.Type() {
+			case diff.Add:
+				d.Additions += lines
+			case diff.Delete:
+				d.Deletions += lines
+			}
+		}
+
+		buf := &bytes.Buffer{}
+		if err := diff.NewUnifiedEncoder(buf, diff.DefaultContextLines).
+			Encode(filePatch{fp}); err != nil {
+			return nil, err
+		}
+		d.Patch = buf.String()
+
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+// GroupDiff is the changes of the limits and the score of a test group.
+type GroupDiff struct {
+	Group string `json:"group"`
+
+	OldTimeLimit   uint64 `json:"old_time_limit"`
+	NewTimeLimit   uint64 `json:"new_time_limit"`
+	OldMemoryLimit uint64 `json:"old_memory_limit"`
+	NewMemoryLimit uint64 `json:"new_memory_limit"`
+	OldFullScore   int32  `json:"old_full_score"`
+	NewFullScore   int32  `json:"new_full_score"`
+}
+
+// SolutionDiff is the changes of the expectations of a solution.
+type SolutionDiff struct {
+	Solution string `json:"solution"`
+
+	PathChanged bool `json:"path_changed"`
+
+	// AddedAccepts and RemovedAccepts are the changes of the groups accepting the solution.
+	AddedAccepts   []string `json:"added_accepts,omitempty"`
+	RemovedAccepts []string `json:"removed_accepts,omitempty"`
+
+	// ScoresChanged is true if the expected score ranges are changed.
+	ScoresChanged bool `json:"scores_changed"`
+}
+
+// ConfigDiff is the semantic diff of the configs of two revisions of a problem.
+//
+// Test cases are identified by their prefixes, like "main-0".
+type ConfigDiff struct {
+	AddedGroups   []string `json:"added_groups"`
+	RemovedGroups []string `json:"removed_groups"`
+
+	AddedTests   []string `json:"added_tests"`
+	RemovedTests []string `json:"removed_tests"`
+
+	// ChangedTests are the test cases whose generators, arguments or flags are changed.
+	ChangedTests []string `json:"changed_tests"`
+
+	// Groups are the test groups whose limits or full scores are changed.
+	Groups []GroupDiff `json:"groups"`
+
+	AddedSolutions   []string       `json:"added_solutions"`
+	RemovedSolutions []string       `json:"removed_solutions"`
+	Solutions        []SolutionDiff `json:"solutions"`
+
+	// Fields are the other changed fields, by their names in config.yaml.
+	Fields []string `json:"fields"`
+}
+
+// diffKeys returns the sorted keys only in a and only in b.
+func diffKeys[V any](a map[string]V, b map[string]V) ([]string, []string) {
+	onlyA, onlyB := []string{}, []string{}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			onlyA = append(onlyA, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			onlyB = append(onlyB, k)
+		}
+	}
+	sort.Strings(onlyA)
+	sort.Strings(onlyB)
+	return onlyA, onlyB
+}
+
+// sortedKeys returns the sorted keys of m.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configTests returns the configs of the test cases by their prefixes.
+func configTests(conf *Config) map[string]TestCaseConfig {
+	tests := map[string]TestCaseConfig{}
+	for group, groupConf := range conf.TestGroups {
+		for i, test := range groupConf.Tests {
+			tests[getTestCasePathPrefix(group, i)] = test
+		}
+	}
+	return tests
+}
+
+// DiffConfig returns the semantic diff of the configs.
+func DiffConfig(from *Config, to *Config) *ConfigDiff {
+	d := &ConfigDiff{
+		ChangedTests: []string{},
+		Groups:       []GroupDiff{},
+		Solutions:    []SolutionDiff{},
+		Fields:       []string{},
+	}
+
+	d.RemovedGroups, d.AddedGroups = diffKeys(from.TestGroups, to.TestGroups)
+	for _, group := range sortedKeys(to.TestGroups) {
+		old, ok := from.TestGroups[group]
+		if !ok {
+			continue
+		}
+		new := to.TestGroups[group]
+		if old.TimeLimit != new.TimeLimit || old.MemoryLimit != new.MemoryLimit ||
+			old.FullScore != new.FullScore {
+			d.Groups = append(d.Groups, GroupDiff{
+				Group:          group,
+				OldTimeLimit:   old.TimeLimit,
+				NewTimeLimit:   new.TimeLimit,
+				OldMemoryLimit: old.MemoryLimit,
+				NewMemoryLimit: new.MemoryLimit,
+				OldFullScore:   old.FullScore,
+				NewFullScore:   new.FullScore,
+			})
+		}
+		if !reflect.DeepEqual(old.Depends, new.Depends) || old.Scoring != new.Scoring {
+			d.Fields = append(d.Fields, "test_groups."+group)
+		}
+	}
+
+	fromTests, toTests := configTests(from), configTests(to)
+	d.RemovedTests, d.AddedTests = diffKeys(fromTests, toTests)
+	for _, prefix := range sortedKeys(toTests) {
+		if old, ok := fromTests[prefix]; ok && !reflect.DeepEqual(old, toTests[prefix]) {
+			d.ChangedTests = append(d.ChangedTests, prefix)
+		}
+	}
+
+	d.RemovedSolutions, d.AddedSolutions = diffKeys(from.Solutions, to.Solutions)
+	for _, name := range sortedKeys(to.Solutions) {
+		old, ok := from.Solutions[name]
+		if !ok {
+			continue
+		}
+		new := to.Solutions[name]
+		sd := SolutionDiff{
+			Solution:      name,
+			PathChanged:   old.Path != new.Path,
+			ScoresChanged: !reflect.DeepEqual(old.Scores, new.Scores),
+		}
+		oldAccepts, newAccepts := map[string]bool{}, map[string]bool{}
+		for _, group := range old.Accepts {
+			oldAccepts[group] = true
+		}
+		for _, group := range new.Accepts {
+			newAccepts[group] = true
+		}
+		sd.RemovedAccepts, sd.AddedAccepts = diffKeys(oldAccepts, newAccepts)
+		if sd.PathChanged || sd.ScoresChanged ||
+			len(sd.AddedAccepts) > 0 || len(sd.RemovedAccepts) > 0 {
+			d.Solutions = append(d.Solutions, sd)
+		}
+	}
+
+	for _, field := range []struct {
+		name     string
+		from, to any
+	}{
+		{"type", from.Type, to.Type},
+		{"statements", from.Statements, to.Statements},
+		{"checker", from.Checker, to.Checker},
+		{"checker_protocol", from.CheckerProtocol, to.CheckerProtocol},
+		{"tolerance", from.Tolerance, to.Tolerance},
+		{"testlib", from.Testlib, to.Testlib},
+		{"includes", from.Includes, to.Includes},
+		{"manager", from.Manager, to.Manager},
+		{"validator", from.Validator, to.Validator},
+		{"generators", from.Generators, to.Generators},
+		{"graders", from.Graders, to.Graders},
+		{"standard_solution", from.StandardSolution, to.StandardSolution},
+		{"fixed_tests", from.FixedTests, to.FixedTests},
+	} {
+		if !reflect.DeepEqual(field.from, field.to) {
+			d.Fields = append(d.Fields, field.name)
+		}
+	}
+	sort.Strings(d.Fields)
+
+	return d
+}
+
+// VerdictDiff is a changed verdict of a solution on a test case between two builds.
+type VerdictDiff struct {
+	Solution string                        `json:"solution"`
+	TestCase string                        `json:"test_case"`
+	From     pb.Response_Result_StatusType `json:"from"`
+	To       pb.Response_Result_StatusType `json:"to"`
+}
+
+// BuildDiff is the diff of the builds of two revisions of a problem.
+type BuildDiff struct {
+	FromOK bool `json:"from_ok"`
+	ToOK   bool `json:"to_ok"`
+
+	// FromFailedPhase and ToFailedPhase are the phases failing the builds, see FailedPhase.
+	FromFailedPhase string `json:"from_failed_phase,omitempty"`
+	ToFailedPhase   string `json:"to_failed_phase,omitempty"`
+
+	// Verdicts are the changed verdicts on the test cases judged in both builds.
+	Verdicts []VerdictDiff `json:"verdicts"`
+}
+
+// DiffBuild returns the diff of the builds.
+func DiffBuild(from *BuildInfo, to *BuildInfo) *BuildDiff {
+	d := &BuildDiff{
+		FromOK:          from.OK,
+		ToOK:            to.OK,
+		FromFailedPhase: from.FailedPhase(),
+		ToFailedPhase:   to.FailedPhase(),
+		Verdicts:        []VerdictDiff{},
+	}
+	if from.Check == nil || to.Check == nil {
+		return d
+	}
+
+	for _, solution := range sortedKeys(to.Check.JudgeResults) {
+		fromResults, ok := from.Check.JudgeResults[solution]
+		if !ok {
+			continue
+		}
+		toResults := to.Check.JudgeResults[solution]
+		for _, test := range sortedKeys(toResults) {
+			fromResult, ok := fromResults[test]
+			if !ok || fromResult == nil || toResults[test] == nil {
+				continue
+			}
+			if fromResult.Status != toResults[test].Status {
+				d.Verdicts = append(d.Verdicts, VerdictDiff{
+					Solution: solution,
+					TestCase: test,
+					From:     fromResult.Status,
+					To:       toResults[test].Status,
+				})
+			}
+		}
+	}
+	return d
+}
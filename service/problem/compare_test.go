@@ -0,0 +1,140 @@
+package problem
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/criyle/go-judge/pb"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// TestDiffFiles tests diffing the files of two revisions.
+func TestDiffFiles(t *testing.T) {
+	repo, hashes := testRepo(t,
+		map[string]string{"config.yaml": "a\n", "std.cpp": "std\n"},
+		map[string]string{"config.yaml": "b\nc\n", "gen.cpp": "gen\n"},
+	)
+	p := NewProblem(uuid.New()).WithRepo(repo)
+
+	diffs, err := p.DiffFiles(hashes[0], hashes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 {
+		t.Fatalf("expected 2 changed files, got %v", diffs)
+	}
+	for _, d := range diffs {
+		switch {
+		case d.From == "config.yaml" && d.To == "config.yaml":
+			if d.Additions != 2 || d.Deletions != 1 || !strings.Contains(d.Patch, "+c\n") {
+				t.Errorf("unexpected diff of 'config.yaml': %v", d)
+			}
+		case d.From == "" && d.To == "gen.cpp":
+			if d.Additions != 1 || d.Deletions != 0 {
+				t.Errorf("unexpected diff of 'gen.cpp': %v", d)
+			}
+		default:
+			t.Errorf("unexpected diff: %v", d)
+		}
+	}
+}
+
+// TestDiffConfig tests the semantic diff of configs.
+func TestDiffConfig(t *testing.T) {
+	parse := func(s string) *Config {
+		conf := &Config{}
+		if err := yaml.Unmarshal([]byte(s), conf); err != nil {
+			t.Fatal(err)
+		}
+		return conf
+	}
+	from := parse(`
+checker: ncmp
+solutions:
+  std: {path: std.cpp, accepts: [main]}
+  brute: {path: brute.cpp, accepts: [main]}
+test_groups:
+  main:
+    time_limit: 1000000000
+    memory_limit: 268435456
+    tests: [{generator: rand, extra_args: ["1"]}, {generator: rand, extra_args: ["2"]}]
+  old: {tests: [{fixed: a}]}
+`)
+	to := parse(`
+checker: wcmp
+solutions:
+  std: {path: std.cpp, accepts: [main, new]}
+  wrong: {path: wrong.cpp, accepts: []}
+test_groups:
+  main:
+    time_limit: 2000000000
+    memory_limit: 268435456
+    tests: [{generator: rand, extra_args: ["1"]}, {generator: rand, extra_args: ["3"]}, {}]
+  new: {tests: [{fixed: b}]}
+`)
+
+	d := DiffConfig(from, to)
+	expected := &ConfigDiff{
+		AddedGroups:   []string{"new"},
+		RemovedGroups: []string{"old"},
+		AddedTests:    []string{"main-2", "new-0"},
+		RemovedTests:  []string{"old-0"},
+		ChangedTests:  []string{"main-1"},
+		Groups: []GroupDiff{{
+			Group:          "main",
+			OldTimeLimit:   1000000000,
+			NewTimeLimit:   2000000000,
+			OldMemoryLimit: 268435456,
+			NewMemoryLimit: 268435456,
+		}},
+		AddedSolutions:   []string{"wrong"},
+		RemovedSolutions: []string{"brute"},
+		Solutions: []SolutionDiff{{
+			Solution:       "std",
+			AddedAccepts:   []string{"new"},
+			RemovedAccepts: []string{},
+		}},
+		Fields: []string{"checker"},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Errorf("expected %+v, got %+v", expected, d)
+	}
+
+	if d := DiffConfig(from, from); len(d.AddedTests)+len(d.ChangedTests)+len(d.Groups)+
+		len(d.Solutions)+len(d.Fields) != 0 {
+		t.Errorf("a config should not differ from itself, got %+v", d)
+	}
+}
+
+// TestDiffBuild tests diffing the verdicts of two builds.
+func TestDiffBuild(t *testing.T) {
+	from := &BuildInfo{OK: true, Check: &CheckInfo{OK: true}}
+	from.Check.JudgeResults = map[string]map[string]*JudgeResult{
+		"std":   {"main-0": {Status: pb.Response_Result_Accepted}},
+		"brute": {"main-0": {Status: pb.Response_Result_TimeLimitExceeded}},
+	}
+	to := &BuildInfo{Check: &CheckInfo{OK: false}}
+	to.Check.JudgeResults = map[string]map[string]*JudgeResult{
+		"std": {
+			"main-0": {Status: pb.Response_Result_WrongAnswer},
+			"main-1": {Status: pb.Response_Result_Accepted},
+		},
+		"brute": {"main-0": {Status: pb.Response_Result_TimeLimitExceeded}},
+	}
+
+	d := DiffBuild(from, to)
+	if !d.FromOK || d.ToOK || d.ToFailedPhase != "check" {
+		t.Errorf("unexpected build diff: %+v", d)
+	}
+	expected := []VerdictDiff{{
+		Solution: "std",
+		TestCase: "main-0",
+		From:     pb.Response_Result_Accepted,
+		To:       pb.Response_Result_WrongAnswer,
+	}}
+	if !reflect.DeepEqual(d.Verdicts, expected) {
+		t.Errorf("expected verdicts %v, got %v", expected, d.Verdicts)
+	}
+}
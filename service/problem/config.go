@@ -1,6 +1,8 @@
 package problem
 
 import (
+	"path"
+
 	"golang.org/x/text/language"
 	"gopkg.in/yaml.v3"
 )
@@ -10,6 +12,9 @@ import (
 // Use YAML as configuration format
 // as there is no toml parsing package to support custom struct as map keys.
 type Config struct {
+	// Type is the type of the problem, see ProblemType.
+	Type ProblemType `yaml:"type,omitempty" json:"type,omitempty"`
+
 	// Statement is a map of language and statement of the problem.
 	Statements map[language.Tag]string `yaml:"statements" json:"statements"`
 
@@ -20,6 +25,26 @@ type Config struct {
 	// - Otherwise an error will be returned.
 	Checker string `yaml:"checker" json:"checker"`
 
+	// CheckerProtocol is the protocol of the checker, see CheckerProtocol.
+	//
+	// With CheckerDiff, Checker is not used.
+	CheckerProtocol CheckerProtocol `yaml:"checker_protocol,omitempty" json:"checker_protocol,omitempty"`
+
+	// Tolerance is the error of floats allowed by CheckerDiff, DefaultTolerance if zero.
+	Tolerance float64 `yaml:"tolerance,omitempty" json:"tolerance,omitempty"`
+
+	// Testlib is the version of the bundled testlib, or the path of a "testlib.h" in the repo.
+	//
+	// If it is empty, the latest testlib is used.
+	Testlib string `yaml:"testlib,omitempty" json:"testlib,omitempty"`
+
+	// Includes are the paths of extra headers, which are copied into the compiles of generators,
+	// validator, checker, manager and solutions by their file names.
+	Includes []string `yaml:"includes,omitempty" json:"includes,omitempty"`
+
+	// Manager is the path of the manager of two-run problems, see ProblemTypeTwoRun.
+	Manager string `yaml:"manager,omitempty" json:"manager,omitempty"`
+
 	// Validator is path of problem validator.
 	Validator string `yaml:"validator" json:"validator"`
 
@@ -27,14 +52,27 @@ type Config struct {
 	Generators map[string]string `yaml:"generators" json:"generators"`
 
 	// Solutions is a map of names and paths to problem solutions.
+	//
+	// For output-only problems, the path is a directory of the output files,
+	// the output of a test case is "<path>/<prefix>.out".
 	Solutions map[string]struct {
 		// Path is path of solution.
 		Path string `yaml:"path" json:"path"`
 
 		// Accepts are the groups which the solution is acceptable to.
 		Accepts []string `yaml:"accepts" json:"accepts"`
+
+		// Scores are the expected score ranges of the solution in the groups.
+		//
+		// It is useful for problems with partial scores, like optimization problems.
+		Scores map[string]ScoreRange `yaml:"scores,omitempty" json:"scores,omitempty"`
 	} `yaml:"solutions" json:"solutions"`
 
+	// Graders is a map of language names and graders of the problem.
+	//
+	// The solutions of the problem are in DefaultLanguage.
+	Graders map[string]GraderConfig `yaml:"graders,omitempty" json:"graders,omitempty"`
+
 	// StandardSolution is the name of the main correct solution.
 	//
 	// If a solution is the standard solution, it should be marked as accepted for all test groups.
@@ -52,6 +90,46 @@ type Config struct {
 	TestGroups map[string]TestGroupConfig `yaml:"test_groups" json:"test_groups"`
 }
 
+// ProblemType is the type of a problem.
+type ProblemType string
+
+const (
+	// ProblemTypeTraditional is a problem whose solutions are executed on the inputs (default).
+	ProblemTypeTraditional ProblemType = "traditional"
+
+	// ProblemTypeOutputOnly is a problem whose inputs are published,
+	// and the contestants submit the output files instead of the source code.
+	ProblemTypeOutputOnly ProblemType = "output_only"
+
+	// ProblemTypeTwoRun is a problem whose solutions run twice on each test case,
+	// like encoder/decoder problems.
+	//
+	// 1. The solution runs with argument "1" on the input.
+	// 2. The manager runs as "manager input.txt output.txt",
+	//    where output.txt is the output of the first run.
+	//    Its output is the input of the second run, and a non-zero exit means wrong answer.
+	// 3. The solution runs with argument "2" on the output of the manager.
+	//
+	// The output of the second run is checked by the checker.
+	ProblemTypeTwoRun ProblemType = "two_run"
+)
+
+// Valid returns true if the type is a known problem type or empty.
+func (t ProblemType) Valid() bool {
+	switch t {
+	case "", ProblemTypeTraditional, ProblemTypeOutputOnly, ProblemTypeTwoRun:
+		return true
+	default:
+		return false
+	}
+}
+
+// OutputPath returns the path of the output file of a test case in the output directory
+// of a solution of an output-only problem.
+func OutputPath(dir string, prefix string) string {
+	return path.Join(dir, prefix+".out")
+}
+
 // GetConfig returns a configuration of a problem.
 func (p *Problem) GetConfig(rev [20]byte) (*Config, error) {
 	confReader, err := p.File(rev, "config.yaml")
@@ -67,6 +145,36 @@ func (p *Problem) GetConfig(rev [20]byte) (*Config, error) {
 	return &conf, nil
 }
 
+// DefaultLanguage is the language of the solutions in the problem repo.
+const DefaultLanguage = "cpp"
+
+// GraderConfig is a config of the grader of a language,
+// for the problems whose contestants implement functions instead of a program.
+type GraderConfig struct {
+	// Sources are the paths of the grader sources, which are compiled together with solutions.
+	//
+	// Their file names are appended to the compile command.
+	Sources []string `yaml:"sources" json:"sources"`
+
+	// Headers are the paths of the headers, which are copied in when compiling solutions.
+	Headers []string `yaml:"headers" json:"headers"`
+
+	// Public are the paths of the files published to contestants,
+	// like a sample grader and the headers.
+	Public []string `yaml:"public" json:"public"`
+}
+
+// ScoreRange is a range of scores, both ends are inclusive.
+type ScoreRange struct {
+	Min float64 `yaml:"min" json:"min"`
+	Max float64 `yaml:"max" json:"max"`
+}
+
+// Contains returns true if the score is in the range.
+func (r ScoreRange) Contains(score float64) bool {
+	return r.Min <= score && score <= r.Max
+}
+
 // TestGroupConfig is a config of test group.
 type TestGroupConfig struct {
 	// Depends is a list of names of test groups that this group depends on.
@@ -74,10 +182,17 @@ type TestGroupConfig struct {
 
 	// FullScore is the score of this group.
 	//
-	// patient's score = FullScore *
+	// With the default scoring mode, patient's score = FullScore *
 	//   min(min_{s \in dependencies} Score(s) / FullScore(s), min_{t \in tests} score(t) / 100)
 	FullScore int32 `yaml:"full_score" json:"full_score"`
 
+	// Scoring is the mode to aggregate the scores of the tests, see ScoringMode.
+	//
+	// - "min" (default): the formula above.
+	// - "sum": the average of score(t) / 100 instead of the minimum.
+	// - "icpc": FullScore if all the tests and dependencies are fully passed, otherwise 0.
+	Scoring ScoringMode `yaml:"scoring,omitempty" json:"scoring,omitempty"`
+
 	// TimeLimit is the time limit in nanoseconds of this group.
 	TimeLimit uint64 `yaml:"time_limit" json:"time_limit"`
 
@@ -0,0 +1,57 @@
+package problem
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/criyle/go-judge/pb"
+)
+
+// DefaultTolerance is the error of floats allowed by CheckerDiff if the tolerance is not set.
+const DefaultTolerance = 1e-6
+
+// floatEqual returns true if the absolute or relative error between a and b is in tolerance.
+func floatEqual(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance*math.Max(1, math.Abs(b))
+}
+
+// DiffOutput compares the output with the answer as sequences of tokens.
+// Returns the status, the score out of fullScore and the message, like ParseTestlibOutput.
+//
+// Two tokens are equal if they are the same string,
+// or they are both floats whose absolute or relative error is in tolerance.
+// If tolerance is zero, DefaultTolerance is used.
+func DiffOutput(ouf, ans []byte, tolerance float64, fullScore int64) (
+	pb.Response_Result_StatusType, int64, string,
+) {
+	if tolerance == 0 {
+		tolerance = DefaultTolerance
+	}
+	oufTokens := strings.Fields(string(ouf))
+	ansTokens := strings.Fields(string(ans))
+
+	for i, expected := range ansTokens {
+		if i >= len(oufTokens) {
+			return pb.Response_Result_WrongAnswer, 0,
+				fmt.Sprintf("answer contains %d tokens, but output contains only %d tokens",
+					len(ansTokens), len(oufTokens))
+		}
+		found := oufTokens[i]
+		if found == expected {
+			continue
+		}
+		a, errA := strconv.ParseFloat(found, 64)
+		b, errB := strconv.ParseFloat(expected, 64)
+		if errA != nil || errB != nil || !floatEqual(a, b, tolerance) {
+			return pb.Response_Result_WrongAnswer, 0, TruncateMessage(
+				fmt.Sprintf("token %d differs: expected '%s', found '%s'", i+1, expected, found))
+		}
+	}
+	if len(oufTokens) > len(ansTokens) {
+		return pb.Response_Result_WrongAnswer, 0,
+			fmt.Sprintf("output contains extra tokens after %d tokens", len(ansTokens))
+	}
+	return pb.Response_Result_Accepted, fullScore, fmt.Sprintf("%d tokens", len(ansTokens))
+}
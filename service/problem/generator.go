@@ -20,11 +20,14 @@ type Generator struct {
 
 	// GetSource is a function returns the source code ReadCloser of the checker.
 	GetSource func() (io.ReadCloser, error)
+
+	// Headers are the headers copied in when compiling the generator, by their file names.
+	Headers map[string][]byte
 }
 
 // NewGenerator creates a generator.
 func NewGenerator(getSource func() (io.ReadCloser, error)) *Generator {
-	return &Generator{binaryID: new(string), GetSource: getSource}
+	return &Generator{binaryID: new(string), GetSource: getSource, Headers: defaultHeaders()}
 }
 
 // NewGeneratorFromProblem creates a generator from a problem.
@@ -43,6 +46,12 @@ func NewGeneratorFromReadCloser(r io.ReadCloser) *Generator {
 	return NewGenerator(func() (io.ReadCloser, error) { return r, nil })
 }
 
+// WithHeaders sets the headers of the generator, see Problem.GetHeaders.
+func (g *Generator) WithHeaders(headers map[string][]byte) *Generator {
+	g.Headers = headers
+	return g
+}
+
 // CompileTask returns the compile task of the generator.
 func (g *Generator) CompileTask(cb judge.CallbackFunction) (*judge.Task, error) {
 	conf := etc.Config
@@ -56,6 +65,7 @@ func (g *Generator) CompileTask(cb judge.CallbackFunction) (*judge.Task, error)
 		return nil, err
 	}
 	return judge.DefaultTask().
+		WithKind(judge.TaskKindCompile).
 		WithCmd(conf.Compile.Cmd...).
 		WithCmd(conf.Generator.Compile.Args...).
 		WithCmd("generator.cpp", "-o", "generator").
@@ -63,7 +73,7 @@ func (g *Generator) CompileTask(cb judge.CallbackFunction) (*judge.Task, error)
 		WithMemoryLimit(conf.Compile.MemoryLimit).
 		WithStderrLimit(conf.Compile.StderrLimit).
 		WithCopyIn("generator.cpp", bytes).
-		WithCopyIn("testlib.h", TestlibSource).
+		WithCopyInFiles(g.Headers).
 		WithCopyOut("generator").
 		WithCallback(func(r *pb.Response_Result, err error) bool {
 			if finished := err == nil && r.Status == pb.Response_Result_Accepted; finished {
@@ -81,6 +91,7 @@ func (g *Generator) CompileTask(cb judge.CallbackFunction) (*judge.Task, error)
 func (g *Generator) GenerateTask(args []string, cb judge.CallbackFunction) *judge.Task {
 	conf := &etc.Config.Generator
 	return judge.DefaultTask().
+		WithKind(judge.TaskKindGenerate).
 		WithCmd("generator").
 		WithCmd(args...).
 		WithTimeLimit(conf.Run.TimeLimit).
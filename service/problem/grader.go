@@ -0,0 +1,52 @@
+package problem
+
+import (
+	"io"
+	"path"
+)
+
+// Grader is the files of a grader to compile solutions with, by their file names.
+type Grader struct {
+	// Sources are the grader sources, which are compiled together with solutions.
+	Sources map[string][]byte
+
+	// Headers are the headers, which are copied in when compiling solutions.
+	Headers map[string][]byte
+}
+
+// readFiles reads the files of the problem by their file names.
+func (p *Problem) readFiles(rev [20]byte, paths []string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, pa := range paths {
+		r, err := p.File(rev, pa)
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[path.Base(pa)] = content
+	}
+	return files, nil
+}
+
+// GetGrader returns the grader of the language in config.
+//
+// If the problem has no grader of the language, returns nil.
+func (p *Problem) GetGrader(rev [20]byte, conf *Config, lang string) (*Grader, error) {
+	gc, ok := conf.Graders[lang]
+	if !ok {
+		return nil, nil
+	}
+	sources, err := p.readFiles(rev, gc.Sources)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := p.readFiles(rev, gc.Headers)
+	if err != nil {
+		return nil, err
+	}
+	return &Grader{Sources: sources, Headers: headers}, nil
+}
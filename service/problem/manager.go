@@ -0,0 +1,207 @@
+package problem
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"rindag/service/etc"
+	"rindag/service/judge"
+
+	"github.com/criyle/go-judge/pb"
+	log "github.com/sirupsen/logrus"
+)
+
+// Manager is a manager between the runs of solutions of two-run problems.
+type Manager struct {
+	// binaryID is the ID of the manager binary.
+	//
+	// If the manager is not compiled, the binaryID will be nil.
+	binaryID *string
+
+	// GetSource is a function returns the source code ReadCloser of the manager.
+	GetSource func() (io.ReadCloser, error)
+
+	// Headers are the headers copied in when compiling the manager, by their file names.
+	Headers map[string][]byte
+}
+
+// NewManager creates a manager.
+func NewManager(getSource func() (io.ReadCloser, error)) *Manager {
+	return &Manager{
+		binaryID:  new(string),
+		GetSource: getSource,
+		Headers:   defaultHeaders(),
+	}
+}
+
+// NewManagerFromProblem creates a manager from a problem.
+func NewManagerFromProblem(problem *Problem, rev [20]byte, path string) *Manager {
+	return NewManager(func() (io.ReadCloser, error) { return problem.File(rev, path) })
+}
+
+// NewManagerFromBytes creates a manager from the source code.
+func NewManagerFromBytes(source []byte) *Manager {
+	return NewManager(
+		func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(source)), nil })
+}
+
+// WithHeaders sets the headers of the manager, see Problem.GetHeaders.
+func (m *Manager) WithHeaders(headers map[string][]byte) *Manager {
+	m.Headers = headers
+	return m
+}
+
+// CompileTask returns the compile task of the manager.
+func (m *Manager) CompileTask(cb judge.CallbackFunction) (*judge.Task, error) {
+	conf := etc.Config
+	source, err := m.GetSource()
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	code, err := io.ReadAll(source)
+	if err != nil {
+		return nil, err
+	}
+	return judge.DefaultTask().
+		WithKind(judge.TaskKindCompile).
+		WithCmd(conf.Compile.Cmd...).
+		WithCmd(conf.Manager.Compile.Args...).
+		WithCmd("manager.cpp", "-o", "manager").
+		WithTimeLimit(conf.Compile.TimeLimit).
+		WithMemoryLimit(conf.Compile.MemoryLimit).
+		WithStderrLimit(conf.Compile.StderrLimit).
+		WithCopyIn("manager.cpp", code).
+		WithCopyInFiles(m.Headers).
+		WithCopyOut("manager").
+		WithCallback(func(r *pb.Response_Result, err error) bool {
+			if finished := err == nil && r.Status == pb.Response_Result_Accepted; finished {
+				ok := false
+				if *m.binaryID, ok = r.FileIDs["manager"]; !ok {
+					// Impossible to happen.
+					log.Fatal("manager compile successful, but binary ID not found")
+				}
+			}
+			return cb(r, err)
+		}), nil
+}
+
+// ManageTask needs an input file and the ID of the output file of the first run.
+// Returns a judge task to run the manager, whose output is the input of the second run.
+func (m *Manager) ManageTask(
+	inf *pb.Request_File, oufID *string, cb judge.CallbackFunction,
+) *judge.Task {
+	conf := &etc.Config.Manager
+	return judge.DefaultTask().
+		WithKind(judge.TaskKindRun).
+		WithCmd("manager", "input.txt", "output.txt").
+		WithTimeLimit(conf.Run.TimeLimit).
+		WithMemoryLimit(conf.Run.MemoryLimit).
+		WithStderrLimit(conf.Run.StderrLimit).
+		WithCopyInCached("manager", m.binaryID).
+		WithCopyInFile("input.txt", inf).
+		WithCopyInCached("output.txt", oufID).
+		WithCallback(cb)
+}
+
+// runPhases are the tasks to run solutions in phases,
+// the tasks of a phase are executed after all the tasks of the previous phase.
+type runPhases [][]*judge.Task
+
+// add adds the tasks of a test case, the i-th task is executed in the i-th phase.
+func (p *runPhases) add(tasks ...*judge.Task) {
+	for i, t := range tasks {
+		if i >= len(*p) {
+			*p = append(*p, []*judge.Task{})
+		}
+		(*p)[i] = append((*p)[i], t)
+	}
+}
+
+// then chains the phases to the request.
+func (p runPhases) then(req *judge.Request) *judge.Request {
+	for _, tasks := range p {
+		req = req.Then(tasks...)
+	}
+	return req
+}
+
+// RunTasks returns the tasks to run the solution on a test case in phases.
+//
+// For two-run problems, they are the first run, the manager and the second run,
+// otherwise it is a single run task, see ProblemTypeTwoRun.
+// The manager is only used for two-run problems.
+//
+// cb is called once with the result of the whole chain:
+// the result of the first failed phase, or the result of the second run,
+// whose time is the total time and memory is the maximum memory of the runs.
+// A failed manager is reported as a wrong answer of the second run.
+func (s *Solution) RunTasks(
+	conf *Config, manager *Manager, timeLimit uint64, memoryLimit uint64, inf *pb.Request_File,
+	cb judge.CallbackFunction,
+) []*judge.Task {
+	if conf.Type != ProblemTypeTwoRun {
+		return []*judge.Task{s.RunTask(timeLimit, memoryLimit, inf, []string{}, cb)}
+	}
+
+	var (
+		first, manage, second *judge.Task
+		firstResult           *pb.Response_Result
+		once                  sync.Once
+	)
+	firstOufID := new(string)
+	secondInfID := new(string)
+
+	// finish calls cb once, and stops the remaining phases.
+	finish := func(r *pb.Response_Result, err error) bool {
+		ok := true
+		once.Do(func() {
+			manage.Stop()
+			second.Stop()
+			ok = cb(r, err)
+		})
+		return ok
+	}
+
+	first = s.RunTask(timeLimit, memoryLimit, inf, []string{"1"},
+		func(r *pb.Response_Result, err error) bool {
+			if err != nil || r.Status != pb.Response_Result_Accepted {
+				return finish(r, err)
+			}
+			firstResult = r
+			*firstOufID = r.FileIDs["stdout"]
+			return true
+		})
+
+	manage = manager.ManageTask(inf, firstOufID,
+		func(r *pb.Response_Result, err error) bool {
+			if err != nil {
+				return finish(nil, err)
+			}
+			if r.Status != pb.Response_Result_Accepted {
+				return finish(&pb.Response_Result{
+					Status: pb.Response_Result_WrongAnswer,
+					Time:   firstResult.Time,
+					Memory: firstResult.Memory,
+					Files:  map[string][]byte{"stderr": r.Files["stderr"]},
+				}, nil)
+			}
+			*secondInfID = r.FileIDs["stdout"]
+			return true
+		})
+
+	second = s.RunTask(timeLimit, memoryLimit, nil, []string{"2"},
+		func(r *pb.Response_Result, err error) bool {
+			if err == nil {
+				r.Time += firstResult.Time
+				if firstResult.Memory > r.Memory {
+					r.Memory = firstResult.Memory
+				}
+			}
+			return finish(r, err)
+		}).
+		WithStdinCached(secondInfID)
+
+	return []*judge.Task{first, manage, second}
+}
@@ -5,16 +5,15 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"path"
 	"strings"
 	"time"
 
-	"rindag/service/storage"
-
-	"github.com/minio/minio-go/v7"
 	"gopkg.in/yaml.v3"
 )
 
-var packageFuncs = map[string]func(*Problem, map[string]*TestGroup, io.Writer) error{
+var packageFuncs = map[string]func(
+	*Problem, [20]byte, *Config, map[string]*TestGroup, io.Writer) error{
 	"luogu": LuoguPackager,
 }
 
@@ -25,7 +24,9 @@ type luoguProblemConfig struct {
 	SubtaskID   int    `yaml:"subtaskId"`
 }
 
-func LuoguPackager(p *Problem, testGroups map[string]*TestGroup, out io.Writer) error {
+func LuoguPackager(
+	p *Problem, rev [20]byte, config *Config, testGroups map[string]*TestGroup, out io.Writer,
+) error {
 	ctx := context.Background()
 	bucket, err := p.Bucket()
 	if err != nil {
@@ -43,10 +44,11 @@ func LuoguPackager(p *Problem, testGroups map[string]*TestGroup, out io.Writer)
 	dataW := zip.NewWriter(data)
 
 	writeToZip := func(path string) error {
-		obj, err := storage.Client.GetObject(ctx, bucket, path, minio.GetObjectOptions{})
+		obj, err := getTestObject(ctx, bucket, rev, path)
 		if err != nil {
 			return err
 		}
+		defer obj.Close()
 
 		fw, err := dataW.Create(path)
 		if err != nil {
@@ -122,6 +124,10 @@ func LuoguPackager(p *Problem, testGroups map[string]*TestGroup, out io.Writer)
 		return err
 	}
 
+	if err := p.packagePublicGraders(rev, config, packW); err != nil {
+		return err
+	}
+
 	scoW, err := packW.Create("scoring.txt")
 	if err != nil {
 		return err
@@ -134,13 +140,75 @@ func LuoguPackager(p *Problem, testGroups map[string]*TestGroup, out io.Writer)
 	return nil
 }
 
-// Package is a function to make a package.
+// packagePublicGraders writes the public grader files for contestants to
+// "graders/<language>/<file>" of the zip file.
+func (p *Problem) packagePublicGraders(rev [20]byte, conf *Config, zw *zip.Writer) error {
+	for lang, g := range conf.Graders {
+		files, err := p.readFiles(rev, g.Public)
+		if err != nil {
+			return err
+		}
+		for name, content := range files {
+			fw, err := zw.Create(path.Join("graders", lang, name))
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(content); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Package is a function to make a package of the problem built at rev with the config.
 func (p *Problem) Package(
-	format string, lang string, testGroups map[string]*TestGroup, out io.Writer,
+	format string, lang string, rev [20]byte, conf *Config, testGroups map[string]*TestGroup,
+	out io.Writer,
 ) error {
 	if _, ok := packageFuncs[format]; !ok {
 		return fmt.Errorf("unknown package format: %s", format)
 	}
 
-	return packageFuncs[format](p, testGroups, out)
+	return packageFuncs[format](p, rev, conf, testGroups, out)
+}
+
+// PackageInputs writes a zip file of the inputs of all test cases of the build at rev,
+// which is published to the contestants of output-only problems.
+func (p *Problem) PackageInputs(
+	rev [20]byte, testGroups map[string]*TestGroup, out io.Writer,
+) error {
+	ctx := context.Background()
+	bucket, err := p.Bucket()
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(out)
+	defer zw.Close()
+
+	for _, group := range testGroups {
+		for _, test := range group.Tests {
+			infPath := test.Prefix + ".in"
+
+			obj, err := getTestObject(ctx, bucket, rev, infPath)
+			if err != nil {
+				return err
+			}
+
+			fw, err := zw.Create(infPath)
+			if err != nil {
+				obj.Close()
+				return err
+			}
+
+			_, err = io.Copy(fw, obj)
+			obj.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
@@ -21,6 +21,10 @@ import (
 // Problem represents a problem.
 type Problem struct {
 	ID uuid.UUID
+
+	// repo is the repository to read the problem from instead of its own repository,
+	// see WithRepo.
+	repo *gogit.Repository
 }
 
 // NewProblem creates a new problem.
@@ -28,6 +32,12 @@ func NewProblem(id uuid.UUID) *Problem {
 	return &Problem{ID: id}
 }
 
+// WithRepo returns a copy of the problem which reads from the repository,
+// like a repository with the objects of a push which is not received yet.
+func (p *Problem) WithRepo(repo *gogit.Repository) *Problem {
+	return &Problem{ID: p.ID, repo: repo}
+}
+
 // File returns a ReadCloser of the file of the problem.
 func (p *Problem) File(rev [20]byte, path string) (io.ReadCloser, error) {
 	repo, err := p.Repo()
@@ -61,7 +71,7 @@ func (p *Problem) initRepo() (*gogit.Repository, error) {
 
 	// Link HEAD to the main branch.
 	if err := sRepo.Storer.SetReference(
-		plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		plumbing.NewSymbolicReference(plumbing.HEAD, git.MainBranch)); err != nil {
 		log.WithError(err).Error("failed to set HEAD")
 		return nil, err
 	}
@@ -76,7 +86,7 @@ func (p *Problem) initRepo() (*gogit.Repository, error) {
 
 	// Link HEAD to the main branch.
 	if err := repo.Storer.SetReference(
-		plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")); err != nil {
+		plumbing.NewSymbolicReference(plumbing.HEAD, git.MainBranch)); err != nil {
 		log.WithError(err).Error("failed to set HEAD")
 		return nil, err
 	}
@@ -139,6 +149,9 @@ func (p *Problem) initRepo() (*gogit.Repository, error) {
 
 // Repo gets or initializes the repository of the problem.
 func (p *Problem) Repo() (*gogit.Repository, error) {
+	if p.repo != nil {
+		return p.repo, nil
+	}
 	if git.RepoExists(p.ID.String()) {
 		return git.OpenRepo(p.ID.String())
 	} else {
@@ -1,13 +1,23 @@
 package problem
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"rindag/service/etc"
+	"rindag/service/git"
 	"rindag/service/judge"
 
 	"github.com/criyle/go-judge/pb"
+	"github.com/google/uuid"
 )
 
 func TestParseTestlibOutputAC(t *testing.T) {
@@ -296,3 +306,285 @@ func TestJudgeAPlusB(t *testing.T) {
 	status := <-result
 	t.Log(status)
 }
+
+// TestCompileWithGrader tests that the grader is copied in and compiled with the solution.
+func TestCompileWithGrader(t *testing.T) {
+	sol := NewSolutionFromBytes([]byte("int add(int a, int b) { return a + b; }")).
+		WithGrader(&Grader{
+			Sources: map[string][]byte{"grader.cpp": []byte("#include \"add.h\"")},
+			Headers: map[string][]byte{"add.h": []byte("int add(int a, int b);")},
+		})
+	task, err := sol.CompileTask(func(*pb.Response_Result, error) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if task.Cmd[len(task.Cmd)-1] != "grader.cpp" {
+		t.Errorf("grader source should be compiled, but the command is %v", task.Cmd)
+	}
+	for _, name := range []string{"sol.cpp", "grader.cpp", "add.h"} {
+		if _, ok := task.CopyIn[name]; !ok {
+			t.Errorf("'%s' should be copied in", name)
+		}
+	}
+}
+
+// TestTwoRunTasks tests that the runs of a two-run problem are chained by the manager.
+func TestTwoRunTasks(t *testing.T) {
+	conf := &Config{Type: ProblemTypeTwoRun}
+	sol := NewSolutionFromBytes([]byte{})
+	manager := NewManagerFromBytes([]byte{})
+	inf := &pb.Request_File{File: &pb.Request_File_Memory{
+		Memory: &pb.Request_MemoryFile{Content: []byte("1 2")},
+	}}
+
+	results := []*pb.Response_Result{}
+	tasks := sol.RunTasks(conf, manager, 1e9, 256<<20, inf,
+		func(r *pb.Response_Result, err error) bool {
+			results = append(results, r)
+			return true
+		})
+	if len(tasks) != 3 {
+		t.Fatalf("expected 3 phases, got %d", len(tasks))
+	}
+	first, manage, second := tasks[0], tasks[1], tasks[2]
+
+	first.Callback(&pb.Response_Result{
+		Status:  pb.Response_Result_Accepted,
+		Time:    1,
+		FileIDs: map[string]string{"stdout": "first"},
+	}, nil)
+	if id := *manage.CopyInCached["output.txt"]; id != "first" {
+		t.Errorf("manager should read the output of the first run, got '%s'", id)
+	}
+
+	manage.Callback(&pb.Response_Result{
+		Status: pb.Response_Result_NonZeroExitStatus,
+		Files:  map[string][]byte{"stderr": []byte("wrong")},
+	}, nil)
+	if !second.Stopped() {
+		t.Error("second run should be stopped when the manager fails")
+	}
+	second.Callback(nil, judge.ErrTaskStopped)
+
+	if len(results) != 1 {
+		t.Fatalf("callback should be called once, got %d", len(results))
+	}
+	if results[0].Status != pb.Response_Result_WrongAnswer || results[0].Time != 1 {
+		t.Errorf("unexpected result: %v", results[0])
+	}
+
+	phases := runPhases{}
+	phases.add(tasks...)
+	phases.add(sol.RunTasks(conf, manager, 1e9, 256<<20, inf, nil)...)
+	if len(phases) != 3 || len(phases[1]) != 2 {
+		t.Errorf("unexpected phases: %v", phases)
+	}
+}
+
+// TestDiffOutput tests the built-in diff with float tolerance.
+func TestDiffOutput(t *testing.T) {
+	tests := []struct {
+		ouf, ans string
+		status   pb.Response_Result_StatusType
+	}{
+		{"1 2\n3", "1\n2 3\n", pb.Response_Result_Accepted},
+		{"0.3333333", "0.333333333", pb.Response_Result_Accepted},
+		{"1000000.1", "1000000", pb.Response_Result_Accepted},
+		{"0.33", "0.333333333", pb.Response_Result_WrongAnswer},
+		{"1 2", "1 2 3", pb.Response_Result_WrongAnswer},
+		{"1 2 3", "1 2", pb.Response_Result_WrongAnswer},
+		{"YES", "yes", pb.Response_Result_WrongAnswer},
+	}
+	for _, test := range tests {
+		status, score, msg := DiffOutput([]byte(test.ouf), []byte(test.ans), 0, 100)
+		if status != test.status {
+			t.Errorf("diff '%s' and '%s': expected %v, got %v (%s)",
+				test.ouf, test.ans, test.status, status, msg)
+		}
+		if (status == pb.Response_Result_Accepted) != (score == 100) {
+			t.Errorf("diff '%s' and '%s': unexpected score %d", test.ouf, test.ans, score)
+		}
+	}
+}
+
+// TestCheckerProtocols tests parsing the results of Kattis and Lemon checkers.
+func TestCheckerProtocols(t *testing.T) {
+	kattis := NewCheckerFromBytes([]byte{}).WithProtocol(CheckerKattis)
+	task := kattis.CheckTask(nil, nil, nil, nil)
+	if task.Cmd[len(task.Cmd)-1] != "feedback/" || len(task.CopyOutMemory) != 1 {
+		t.Errorf("unexpected Kattis check task: %v", task.Cmd)
+	}
+	status, score, msg := kattis.ParseResult(&pb.Response_Result{
+		Status:     pb.Response_Result_NonZeroExitStatus,
+		ExitStatus: kattisWrongAnswer,
+		Files:      map[string][]byte{"feedback/judgemessage.txt": []byte("wrong\n")},
+	}, 10)
+	if status != pb.Response_Result_WrongAnswer || score != 0 || msg != "wrong" {
+		t.Errorf("unexpected Kattis result: %v %d %s", status, score, msg)
+	}
+	status, score, _ = kattis.ParseResult(&pb.Response_Result{
+		Status:     pb.Response_Result_NonZeroExitStatus,
+		ExitStatus: kattisAccepted,
+	}, 10)
+	if status != pb.Response_Result_Accepted || score != 10 {
+		t.Errorf("unexpected Kattis result: %v %d", status, score)
+	}
+
+	lemon := NewCheckerFromBytes([]byte{}).WithProtocol(CheckerLemon)
+	status, score, msg = lemon.ParseResult(&pb.Response_Result{
+		Status: pb.Response_Result_Accepted,
+		Files:  map[string][]byte{"score.txt": []byte("60\n"), "message.txt": []byte("ok")},
+	}, 10)
+	if status != pb.Response_Result_PartiallyCorrect || score != 6 || msg != "ok" {
+		t.Errorf("unexpected Lemon result: %v %d %s", status, score, msg)
+	}
+	status, _, _ = lemon.ParseResult(&pb.Response_Result{Status: pb.Response_Result_Accepted}, 10)
+	if status != pb.Response_Result_JudgementFailed {
+		t.Errorf("Lemon checker without score should fail, got %v", status)
+	}
+}
+
+// TestBundledTestlib tests pinning a bundled testlib version.
+func TestBundledTestlib(t *testing.T) {
+	oldDir := etc.Config.Testlib.Dir
+	defer func() { etc.Config.Testlib.Dir = oldDir }()
+
+	// The embedded versions are available without the directory.
+	etc.Config.Testlib.Dir = ""
+	if source, err := BundledTestlib("0.9.12"); err != nil || len(source) == 0 {
+		t.Errorf("version '0.9.12' should be embedded, got %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "0.9.12"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(
+		filepath.Join(dir, "0.9.12", "testlib.h"), []byte("// 0.9.12"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	etc.Config.Testlib.Dir = dir
+
+	if source, err := BundledTestlib("0.9.12"); err != nil || string(source) != "// 0.9.12" {
+		t.Errorf("the directory should override the embedded version: %s, %v", source, err)
+	}
+	if _, err := BundledTestlib("0.9.41"); err != nil {
+		t.Errorf("version '0.9.41' should be embedded, got %v", err)
+	}
+	for _, version := range []string{"0.9.13", "../0.9.12", "..", ""} {
+		if _, err := BundledTestlib(version); !errors.Is(err, ErrUnknownTestlib) {
+			t.Errorf("version '%s' should be unknown, got %v", version, err)
+		}
+	}
+
+	validator := NewValidatorFromBytes([]byte{}).
+		WithHeaders(map[string][]byte{"testlib.h": []byte("// 0.9.12"), "graph.h": {}})
+	task, err := validator.CompileTask(func(*pb.Response_Result, error) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"testlib.h", "graph.h"} {
+		if _, ok := task.CopyIn[name]; !ok {
+			t.Errorf("'%s' should be copied in", name)
+		}
+	}
+}
+
+// TestBundledTestlibVersions tests that the bundled testlib versions are the headers of testlib.
+func TestBundledTestlibVersions(t *testing.T) {
+	for _, version := range []string{"0.9.12", "0.9.34", "0.9.41"} {
+		source, err := bundledTestlibs.ReadFile(path.Join("testlib", version, "testlib.h"))
+		if err != nil {
+			t.Errorf("version '%s' should be bundled, run update-testlib.sh: %v", version, err)
+			continue
+		}
+		if !bytes.Contains(source, []byte("registerTestlibCmd")) ||
+			!bytes.Contains(source, []byte(`"`+version+`"`)) {
+			t.Errorf("version '%s' should be the testlib of the version", version)
+		}
+	}
+}
+
+// TestBuildInfoFailedPhase tests finding the phase failing a build.
+func TestBuildInfoFailedPhase(t *testing.T) {
+	cases := []struct {
+		info  BuildInfo
+		phase string
+	}{
+		{BuildInfo{}, ""},
+		{BuildInfo{Parse: &ParseInfo{OK: false}}, "parse"},
+		{BuildInfo{Parse: &ParseInfo{OK: true}, Generate: &GenerateInfo{OK: false}}, "generate"},
+		{BuildInfo{
+			Parse:    &ParseInfo{OK: true},
+			Generate: &GenerateInfo{OK: true},
+			Validate: &ValidateInfo{OK: true},
+			Check:    &CheckInfo{OK: false},
+		}, "check"},
+		{BuildInfo{OK: true, Parse: &ParseInfo{OK: true}}, ""},
+	}
+	for _, c := range cases {
+		if phase := c.info.FailedPhase(); phase != c.phase {
+			t.Errorf("expected failed phase '%s', got '%s'", c.phase, phase)
+		}
+	}
+}
+
+// TestBuildCancelled tests that a cancelled build returns without blocking the judge,
+// though the callbacks of all its tasks are still called.
+func TestBuildCancelled(t *testing.T) {
+	tests := strings.Repeat("      - generator: rand\n", 20)
+	oldDir, oldWorktree := etc.Config.Git.RepoDir, etc.Config.Problem.InitialWorktree
+	etc.Config.Git.RepoDir = t.TempDir()
+	etc.Config.Problem.InitialWorktree = map[string]string{
+		"config.yaml": `checker: wcmp
+validator: val.cpp
+generators:
+  rand: gen.cpp
+solutions:
+  std:
+    path: std.cpp
+    accepts: [all]
+standard_solution: std
+test_groups:
+  all:
+    full_score: 100
+    time_limit: 1000000000
+    memory_limit: 268435456
+    tests:
+` + tests,
+		"gen.cpp": "", "std.cpp": "", "val.cpp": "",
+	}
+	defer func() {
+		etc.Config.Git.RepoDir, etc.Config.Problem.InitialWorktree = oldDir, oldWorktree
+	}()
+	err := judge.AddAndStart("build-cancelled", judge.LocalHost, "", 1)
+	if err != nil && !errors.Is(err, judge.ErrJudgeExists) {
+		t.Fatal(err)
+	}
+
+	p := NewProblem(uuid.New())
+	rev, err := p.ResolveRef(git.MainBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	info, _ := p.Build(ctx, rev)
+	if !info.Parse.OK {
+		t.Fatalf("config should be parsed: %s", info.Parse.Err)
+	}
+	if info.OK {
+		t.Fatal("cancelled build should fail")
+	}
+
+	// The request and the goroutines receiving the responses end after all callbacks.
+	for i := 0; runtime.NumGoroutine() > goroutines; i++ {
+		if i == 50 {
+			t.Fatalf("%d goroutines are left", runtime.NumGoroutine()-goroutines)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,161 @@
+package problem
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var (
+	// ErrInvalidRefName is returned when the name of a branch or tag is invalid.
+	ErrInvalidRefName = errors.New("invalid ref name")
+
+	// ErrRefExists is returned when creating a branch or tag which already exists.
+	ErrRefExists = errors.New("ref already exists")
+)
+
+// refNameComponentRegexp matches a component of a branch or tag name separated by "/".
+var refNameComponentRegexp = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// ValidRefName returns true if the name is a valid branch or tag name, like "v1" or "draft/a".
+//
+// It is stricter than "git check-ref-format".
+func ValidRefName(name string) bool {
+	if name == "" || name == "HEAD" || strings.Contains(name, "..") {
+		return false
+	}
+	for _, component := range strings.Split(name, "/") {
+		if !refNameComponentRegexp.MatchString(component) ||
+			strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	return true
+}
+
+// Ref is a branch or tag of a problem.
+type Ref struct {
+	// Name is the short name, like "main".
+	Name string `json:"name"`
+
+	// Commit is the hash of the commit of the ref, which is peeled for annotated tags.
+	Commit string `json:"commit"`
+}
+
+// peelRef returns the commit of the ref, which is the commit of the tag for annotated tags.
+func peelRef(repo *gogit.Repository, ref *plumbing.Reference) (plumbing.Hash, error) {
+	tag, err := repo.TagObject(ref.Hash())
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return ref.Hash(), nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	commit, err := tag.Commit()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return commit.Hash, nil
+}
+
+// refs returns the refs of the problem with the prefix, like "refs/heads/".
+func (p *Problem) refs(prefix string) ([]Ref, error) {
+	repo, err := p.Repo()
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	refs := []Ref{}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !strings.HasPrefix(ref.Name().String(), prefix) {
+			return nil
+		}
+		hash, err := peelRef(repo, ref)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, Ref{
+			Name:   strings.TrimPrefix(ref.Name().String(), prefix),
+			Commit: hash.String(),
+		})
+		return nil
+	})
+	return refs, err
+}
+
+// Branches returns the branches of the problem.
+func (p *Problem) Branches() ([]Ref, error) {
+	return p.refs("refs/heads/")
+}
+
+// Tags returns the tags of the problem.
+func (p *Problem) Tags() ([]Ref, error) {
+	return p.refs("refs/tags/")
+}
+
+// ResolveRef returns the commit of a branch or tag, which is peeled for annotated tags.
+func (p *Problem) ResolveRef(name plumbing.ReferenceName) ([20]byte, error) {
+	var rev [20]byte
+
+	repo, err := p.Repo()
+	if err != nil {
+		return rev, err
+	}
+
+	ref, err := repo.Reference(name, true)
+	if err != nil {
+		return rev, err
+	}
+	return peelRef(repo, ref)
+}
+
+// CreateRef creates a branch or tag at the commit rev, like "refs/tags/v1".
+//
+// Returns ErrRefExists if the ref already exists.
+func (p *Problem) CreateRef(name plumbing.ReferenceName, rev [20]byte) error {
+	if !ValidRefName(name.Short()) {
+		return ErrInvalidRefName
+	}
+
+	repo, err := p.Repo()
+	if err != nil {
+		return err
+	}
+
+	if _, err := repo.CommitObject(rev); err != nil {
+		return err
+	}
+
+	if _, err := repo.Reference(name, false); err == nil {
+		return ErrRefExists
+	} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return err
+	}
+
+	return repo.Storer.SetReference(plumbing.NewHashReference(name, rev))
+}
+
+// DeleteRef deletes a branch or tag.
+//
+// Returns plumbing.ErrReferenceNotFound if the ref does not exist.
+func (p *Problem) DeleteRef(name plumbing.ReferenceName) error {
+	repo, err := p.Repo()
+	if err != nil {
+		return err
+	}
+
+	if _, err := repo.Reference(name, false); err != nil {
+		return err
+	}
+
+	return repo.Storer.RemoveReference(name)
+}
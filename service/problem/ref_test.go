@@ -0,0 +1,84 @@
+package problem
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/uuid"
+)
+
+// TestValidRefName tests the names of branches and tags.
+func TestValidRefName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"main":         true,
+		"v1":           true,
+		"contest-2026": true,
+		"draft/a.b":    true,
+		"":             false,
+		"HEAD":         false,
+		"a..b":         false,
+		"/a":           false,
+		"a/":           false,
+		".a":           false,
+		"a.lock":       false,
+		"a b":          false,
+		"a~1":          false,
+	} {
+		if ValidRefName(name) != valid {
+			t.Errorf("ValidRefName(%q) should be %v", name, valid)
+		}
+	}
+}
+
+// TestRefs tests creating, listing and deleting branches and tags.
+func TestRefs(t *testing.T) {
+	repo, hashes := testRepo(t, map[string]string{"config.yaml": "1"}, map[string]string{"a": ""})
+	p := NewProblem(uuid.New()).WithRepo(repo)
+
+	tag := plumbing.NewTagReferenceName("v1")
+	if err := p.CreateRef(tag, hashes[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreateRef(tag, hashes[1]); !errors.Is(err, ErrRefExists) {
+		t.Errorf("expected ErrRefExists, got %v", err)
+	}
+	err := p.CreateRef(plumbing.NewTagReferenceName("a..b"), hashes[1])
+	if !errors.Is(err, ErrInvalidRefName) {
+		t.Errorf("expected ErrInvalidRefName, got %v", err)
+	}
+
+	tags, err := p.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "v1" ||
+		tags[0].Commit != plumbing.Hash(hashes[0]).String() {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+	if rev, err := p.ResolveRef(tag); err != nil || rev != hashes[0] {
+		t.Errorf("unexpected commit of the tag: %v, %v", rev, err)
+	}
+
+	if err := p.CreateRef(plumbing.NewBranchReferenceName("draft/a"), hashes[1]); err != nil {
+		t.Fatal(err)
+	}
+	branches, err := p.Branches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, b := range branches {
+		names[b.Name] = true
+	}
+	if !names["draft/a"] {
+		t.Errorf("unexpected branches: %v", branches)
+	}
+
+	if err := p.DeleteRef(tag); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeleteRef(tag); !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		t.Errorf("expected ErrReferenceNotFound, got %v", err)
+	}
+}
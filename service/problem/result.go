@@ -13,7 +13,16 @@ type RunResult struct {
 }
 
 // ParseRunResult parses a run result from a judge response result.
+//
+// If the task failed to execute, r may be nil.
 func ParseRunResult(r *pb.Response_Result, err error) *RunResult {
+	if r == nil {
+		return &RunResult{
+			Finished: false,
+			Err:      err,
+			Status:   pb.Response_Result_JudgementFailed,
+		}
+	}
 	return &RunResult{
 		Finished: err == nil && r.Status == pb.Response_Result_Accepted,
 		Err:      err,
@@ -29,6 +38,7 @@ type JudgeResult struct {
 	Status        pb.Response_Result_StatusType `json:"status"`
 	Time          uint64                        `json:"time"`
 	Memory        uint64                        `json:"memory"`
+	Score         int64                         `json:"score"`
 	CheckerResult string                        `json:"checker_result"`
 	Inf           string                        `json:"inf"`
 	Ouf           string                        `json:"ouf"`
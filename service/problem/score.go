@@ -0,0 +1,130 @@
+package problem
+
+import (
+	"fmt"
+	"math"
+)
+
+// TestFullScore is the full score of a single test case.
+const TestFullScore = 100
+
+// ScoringMode is the mode to aggregate the scores of test cases in a test group.
+type ScoringMode string
+
+const (
+	// ScoringMin takes the minimum ratio of the tests and the dependencies (default).
+	ScoringMin ScoringMode = "min"
+
+	// ScoringSum takes the average ratio of the tests, limited by the dependencies.
+	ScoringSum ScoringMode = "sum"
+
+	// ScoringICPC gives the full score only if all the tests and the dependencies are fully
+	// passed, otherwise zero.
+	ScoringICPC ScoringMode = "icpc"
+)
+
+// Valid returns true if the mode is a known scoring mode or empty.
+func (m ScoringMode) Valid() bool {
+	switch m {
+	case "", ScoringMin, ScoringSum, ScoringICPC:
+		return true
+	default:
+		return false
+	}
+}
+
+// groupRatio returns the ratio of the score of a group to its full score,
+// with the minimum ratio of its dependencies and the ratios of its tests.
+func groupRatio(mode ScoringMode, depRatio float64, testRatios []float64) float64 {
+	switch mode {
+	case ScoringSum:
+		if len(testRatios) == 0 {
+			return depRatio
+		}
+		sum := 0.0
+		for _, r := range testRatios {
+			sum += r
+		}
+		return math.Min(depRatio, sum/float64(len(testRatios)))
+	case ScoringICPC:
+		if depRatio < 1 {
+			return 0
+		}
+		for _, r := range testRatios {
+			if r < 1 {
+				return 0
+			}
+		}
+		return 1
+	default:
+		r := depRatio
+		for _, t := range testRatios {
+			r = math.Min(r, t)
+		}
+		return r
+	}
+}
+
+// GroupScores returns the scores of test groups, with the scores of test cases out of
+// TestFullScore, like the scores parsed by ParseTestlibOutput.
+//
+// The scores of a group are aggregated by its scoring mode,
+// and are limited by the minimum ratio of its dependencies.
+// A group with zero full score still passes its ratio to the groups depending on it.
+// A test case without a score is considered as zero.
+func GroupScores(
+	testGroups map[string]*TestGroup, testScores map[string]int64,
+) (map[string]float64, error) {
+	ratios := make(map[string]float64)
+	visiting := make(map[string]bool)
+
+	var ratio func(name string) (float64, error)
+	ratio = func(name string) (float64, error) {
+		if r, ok := ratios[name]; ok {
+			return r, nil
+		}
+		group, ok := testGroups[name]
+		if !ok {
+			return 0, fmt.Errorf("test group '%s' not found", name)
+		}
+		if visiting[name] {
+			return 0, fmt.Errorf("test group '%s' depends on itself", name)
+		}
+		visiting[name] = true
+
+		depRatio := 1.0
+		for _, dep := range group.Depends {
+			r, err := ratio(dep)
+			if err != nil {
+				return 0, err
+			}
+			depRatio = math.Min(depRatio, r)
+		}
+		testRatios := make([]float64, len(group.Tests))
+		for i, test := range group.Tests {
+			testRatios[i] = math.Max(0, math.Min(1, float64(testScores[test.Prefix])/TestFullScore))
+		}
+
+		ratios[name] = groupRatio(group.Scoring, depRatio, testRatios)
+		return ratios[name], nil
+	}
+
+	scores := make(map[string]float64)
+	for name, group := range testGroups {
+		r, err := ratio(name)
+		if err != nil {
+			return nil, err
+		}
+		scores[name] = float64(group.FullScore) * r
+	}
+	return scores, nil
+}
+
+// TotalScore returns the total score of the scores of test groups.
+func TotalScore(groupScores map[string]float64) float64 {
+	total := 0.0
+	for _, score := range groupScores {
+		total += score
+	}
+	return total
+}
@@ -0,0 +1,73 @@
+package problem
+
+import "testing"
+
+// TestGroupScores tests the scores of groups with dependencies.
+func TestGroupScores(t *testing.T) {
+	testGroups := map[string]*TestGroup{
+		"sample": {FullScore: 0, Tests: []TestCase{{Prefix: "sample/0"}}},
+		"1":      {Depends: []string{"sample"}, FullScore: 40, Tests: []TestCase{{Prefix: "1/0"}}},
+		"2": {
+			Depends:   []string{"1"},
+			FullScore: 60,
+			Tests:     []TestCase{{Prefix: "2/0"}, {Prefix: "2/1"}},
+		},
+	}
+
+	scores, err := GroupScores(testGroups, map[string]int64{
+		"sample/0": 100, "1/0": 50, "2/0": 100, "2/1": 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scores["1"] != 20 || scores["2"] != 30 {
+		t.Errorf("scores should be 20 and 30, but %v", scores)
+	}
+
+	scores, err = GroupScores(testGroups, map[string]int64{"1/0": 100, "2/0": 100, "2/1": 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scores["1"] != 0 || scores["2"] != 0 {
+		t.Errorf("groups depending on a failed sample should be 0, but %v", scores)
+	}
+
+	testGroups["sample"].Depends = []string{"2"}
+	if _, err := GroupScores(testGroups, map[string]int64{}); err == nil {
+		t.Errorf("circular dependencies should be reported")
+	}
+}
+
+// TestGroupScoresModes tests the scoring modes of groups.
+func TestGroupScoresModes(t *testing.T) {
+	tests := []TestCase{{Prefix: "a"}, {Prefix: "b"}}
+	testScores := map[string]int64{"a": 100, "b": 50}
+
+	for mode, want := range map[ScoringMode]float64{
+		"":          50,
+		ScoringMin:  50,
+		ScoringSum:  75,
+		ScoringICPC: 0,
+	} {
+		scores, err := GroupScores(map[string]*TestGroup{
+			"main": {FullScore: 100, Scoring: mode, Tests: tests},
+		}, testScores)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scores["main"] != want {
+			t.Errorf("score of mode '%s' should be %v, but %v", mode, want, scores["main"])
+		}
+	}
+
+	testScores["b"] = 100
+	scores, err := GroupScores(map[string]*TestGroup{
+		"main": {FullScore: 100, Scoring: ScoringICPC, Tests: tests},
+	}, testScores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if TotalScore(scores) != 100 {
+		t.Errorf("total score of an accepted ICPC group should be 100, but %v", TotalScore(scores))
+	}
+}
@@ -2,7 +2,9 @@ package problem
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"sort"
 
 	"rindag/service/etc"
 	"rindag/service/judge"
@@ -20,8 +22,22 @@ type Solution struct {
 
 	// GetSource is a function returns the source code ReadCloser of the checker.
 	GetSource func() (io.ReadCloser, error)
+
+	// Language is the name of the language of the solution in config.
+	//
+	// If it is empty, the solution is compiled as C++ by the compile command in config.
+	Language string
+
+	// Grader is the grader to compile the solution with, it is nil if there is no grader.
+	Grader *Grader
+
+	// Headers are the extra headers copied in when compiling the solution, by their file names.
+	Headers map[string][]byte
 }
 
+// ErrUnknownLanguage is returned when the language of a solution is not in config.
+var ErrUnknownLanguage = errors.New("unknown language")
+
 // NewSolution creates a solution.
 func NewSolution(getSource func() (io.ReadCloser, error)) *Solution {
 	return &Solution{
@@ -46,9 +62,45 @@ func NewSolutionFromReadCloser(r io.ReadCloser) *Solution {
 	return NewSolution(func() (io.ReadCloser, error) { return r, nil })
 }
 
+// WithLanguage sets the language of the solution.
+func (s *Solution) WithLanguage(lang string) *Solution {
+	s.Language = lang
+	return s
+}
+
+// WithGrader sets the grader of the solution.
+func (s *Solution) WithGrader(grader *Grader) *Solution {
+	s.Grader = grader
+	return s
+}
+
+// WithHeaders sets the extra headers of the solution, see Problem.GetHeaders.
+func (s *Solution) WithHeaders(headers map[string][]byte) *Solution {
+	s.Headers = headers
+	return s
+}
+
+// commands returns the source name, the binary name, the compile command and the run command
+// of the solution.
+func (s *Solution) commands() (string, string, []string, []string, error) {
+	if s.Language == "" {
+		cmd := append(append([]string{}, etc.Config.Compile.Cmd...), "sol.cpp", "-o", "sol")
+		return "sol.cpp", "sol", cmd, []string{"sol"}, nil
+	}
+	lang, ok := etc.Config.Languages[s.Language]
+	if !ok {
+		return "", "", nil, nil, ErrUnknownLanguage
+	}
+	return lang.Source, lang.Binary, lang.CompileCmd, lang.RunCmd, nil
+}
+
 // CompileTask returns a compile task of the solution.
 func (s *Solution) CompileTask(cb judge.CallbackFunction) (*judge.Task, error) {
 	conf := etc.Config
+	sourceName, binaryName, compileCmd, _, err := s.commands()
+	if err != nil {
+		return nil, err
+	}
 	source, err := s.GetSource()
 	if err != nil {
 		return nil, err
@@ -58,18 +110,32 @@ func (s *Solution) CompileTask(cb judge.CallbackFunction) (*judge.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	return judge.DefaultTask().
-		WithCmd(conf.Compile.Cmd...).
-		WithCmd("sol.cpp", "-o", "sol").
+	task := judge.DefaultTask().
+		WithKind(judge.TaskKindCompile).
+		WithCmd(compileCmd...)
+	if s.Grader != nil {
+		names := make([]string, 0, len(s.Grader.Sources))
+		for name, content := range s.Grader.Sources {
+			names = append(names, name)
+			task.WithCopyIn(name, content)
+		}
+		sort.Strings(names)
+		task.WithCmd(names...)
+		for name, content := range s.Grader.Headers {
+			task.WithCopyIn(name, content)
+		}
+	}
+	return task.
 		WithTimeLimit(conf.Compile.TimeLimit).
 		WithMemoryLimit(conf.Compile.MemoryLimit).
 		WithStderrLimit(conf.Compile.StderrLimit).
-		WithCopyIn("sol.cpp", code).
-		WithCopyOut("sol").
+		WithCopyInFiles(s.Headers).
+		WithCopyIn(sourceName, code).
+		WithCopyOut(binaryName).
 		WithCallback(func(r *pb.Response_Result, err error) bool {
 			if finished := err == nil && r.Status == pb.Response_Result_Accepted; finished {
 				ok := false
-				if *s.binaryID, ok = r.FileIDs["sol"]; !ok {
+				if *s.binaryID, ok = r.FileIDs[binaryName]; !ok {
 					// Impossible to happen.
 					log.Fatal("checker compile successful, but binary ID not found")
 				}
@@ -87,12 +153,15 @@ func (s *Solution) RunTask(
 	args []string,
 	cb judge.CallbackFunction,
 ) *judge.Task {
+	// The language has been checked by CompileTask.
+	_, binaryName, _, runCmd, _ := s.commands()
 	return judge.DefaultTask().
-		WithCmd("sol").
+		WithKind(judge.TaskKindRun).
+		WithCmd(runCmd...).
 		WithCmd(args...).
 		WithTimeLimit(timeLimit).
 		WithMemoryLimit(memoryLimit).
 		WithStdinFile(inf).
-		WithCopyInCached("sol", s.binaryID).
+		WithCopyInCached(binaryName, s.binaryID).
 		WithCallback(cb)
 }
@@ -3,11 +3,17 @@ package problem
 import (
 	"context"
 	"io"
+	"path"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 
+	"rindag/service/metrics"
 	"rindag/service/storage"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/minio/minio-go/v7"
 	log "github.com/sirupsen/logrus"
 )
@@ -31,18 +37,73 @@ func (p *Problem) Bucket() (string, error) {
 	return bucket, nil
 }
 
-func clearBucket(ctx context.Context, bucket string) error {
-	objectsCh := make(chan minio.ObjectInfo, 16)
+// testObjectName returns the name of the object of a test file of the build at rev,
+// like "<rev>/1.in".
+func testObjectName(rev [20]byte, pa string) string {
+	return path.Join(plumbing.Hash(rev).String(), pa)
+}
 
-	for object := range storage.Client.ListObjects(ctx, bucket, minio.ListObjectsOptions{
-		Recursive: true,
-	}) {
-		if err := object.Err; err != nil {
-			return err
+// getTestObject returns the object of a test file of the build at rev, like "1.in".
+//
+// The tests saved before they are stored by revisions are at the root of the bucket,
+// they are read if the build has no tests stored.
+func getTestObject(
+	ctx context.Context, bucket string, rev [20]byte, pa string,
+) (*minio.Object, error) {
+	obj, err := storage.Client.GetObject(
+		ctx, bucket, testObjectName(rev, pa), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return nil, err
 		}
-		objectsCh <- object
+		return storage.Client.GetObject(ctx, bucket, pa, minio.GetObjectOptions{})
+	}
+	return obj, nil
+}
+
+// StorageClean removes the tests of the builds not in keep from the storage,
+// which are uploaded before the time.
+//
+// The tests uploaded later may be of running builds, so they are kept.
+// The tests at the root of the bucket, which are saved before they are stored by revisions,
+// are not removed.
+func (p *Problem) StorageClean(keep [][20]byte, before time.Time) error {
+	bucket, err := p.Bucket()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kept := make(map[string]bool)
+	for _, rev := range keep {
+		kept[plumbing.Hash(rev).String()] = true
 	}
-	close(objectsCh)
+
+	objectsCh := make(chan minio.ObjectInfo, 16)
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(objectsCh)
+		for object := range storage.Client.ListObjects(ctx, bucket, minio.ListObjectsOptions{
+			Recursive: true,
+		}) {
+			if err := object.Err; err != nil {
+				listErr <- err
+				return
+			}
+			rev, _, ok := strings.Cut(object.Key, "/")
+			if !ok || !revRegexp.MatchString(rev) || kept[rev] ||
+				!object.LastModified.Before(before) {
+				continue
+			}
+			objectsCh <- object
+		}
+	}()
 
 	for err := range storage.Client.RemoveObjects(
 		ctx, bucket, objectsCh, minio.RemoveObjectsOptions{}) {
@@ -51,11 +112,45 @@ func clearBucket(ctx context.Context, bucket string) error {
 		}
 	}
 
-	return nil
+	select {
+	case err := <-listErr:
+		return err
+	default:
+		return nil
+	}
 }
 
-// StorageSave storages the problem in the storage provider.
-func (p *Problem) StorageSave(testGroups map[string]*TestGroup, fs billy.Filesystem) error {
+// revRegexp matches the hash of a commit.
+var revRegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// StorageHas returns true if the tests of the build at rev are stored, see StorageSave.
+func (p *Problem) StorageHas(rev [20]byte, testGroups map[string]*TestGroup) (bool, error) {
+	bucket, err := p.Bucket()
+	if err != nil {
+		return false, err
+	}
+
+	for _, group := range testGroups {
+		for _, test := range group.Tests {
+			_, err := storage.Client.StatObject(context.Background(), bucket,
+				testObjectName(rev, test.Prefix+".in"), minio.StatObjectOptions{})
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// StorageSave storages the tests of the build at rev in the storage provider.
+//
+// The tests of other builds are not changed, see StorageClean.
+func (p *Problem) StorageSave(
+	rev [20]byte, testGroups map[string]*TestGroup, fs billy.Filesystem,
+) error {
 	bucket, err := p.Bucket()
 	if err != nil {
 		return err
@@ -64,21 +159,15 @@ func (p *Problem) StorageSave(testGroups map[string]*TestGroup, fs billy.Filesys
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	clearBucket(ctx, bucket)
-
-	errChan := make(chan error, 16)
+	errChan := make(chan error, 1)
 	wg := &sync.WaitGroup{}
 
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
-
 	copyToStorage := func(pa string) error {
 		file, err := fs.Open(pa)
 		if err != nil {
 			return err
 		}
+		defer file.Close()
 		info, err := fs.Stat(pa)
 		if info == nil {
 			return err
@@ -86,46 +175,51 @@ func (p *Problem) StorageSave(testGroups map[string]*TestGroup, fs billy.Filesys
 
 		log.WithField("size", info.Size()).Debug("Uploading file")
 
-		if _, err := storage.Client.PutObject(
-			ctx, bucket, pa, file, info.Size(), minio.PutObjectOptions{}); err != nil {
+		if _, err := storage.Client.PutObject(ctx, bucket, testObjectName(rev, pa), file,
+			info.Size(), minio.PutObjectOptions{}); err != nil {
 			return err
 		}
+		metrics.StorageUploadBytes.Add(float64(info.Size()))
 
 		return nil
 	}
 
 	for _, group := range testGroups {
-		wg.Add(len(group.Tests))
 		for _, test := range group.Tests {
+			wg.Add(1)
 			go func(test TestCase) {
-				infPath := test.Prefix + ".in"
-				ansPath := test.Prefix + ".ans"
-
-				if err := copyToStorage(infPath); err != nil {
-					errChan <- err
-					cancel()
-					return
-				}
-
-				if err := copyToStorage(ansPath); err != nil {
-					errChan <- err
-					cancel()
-					return
+				defer wg.Done()
+				for _, pa := range []string{test.Prefix + ".in", test.Prefix + ".ans"} {
+					if err := copyToStorage(pa); err != nil {
+						// Only the first error is returned.
+						select {
+						case errChan <- err:
+						default:
+						}
+						cancel()
+						return
+					}
 				}
-
-				wg.Done()
 			}(test)
 		}
 	}
 
+	go func() {
+		wg.Wait()
+		close(errChan)
+	}()
+
 	for err := range errChan {
 		return err
 	}
 	return nil
 }
 
-// StorageLoad loads the problem from the storage provider, and save it to file system.
-func (p *Problem) StorageLoad(testGroups map[string]*TestGroup, fs billy.Filesystem) error {
+// StorageLoad loads the tests of the build at rev from the storage provider,
+// and save them to file system.
+func (p *Problem) StorageLoad(
+	rev [20]byte, testGroups map[string]*TestGroup, fs billy.Filesystem,
+) error {
 	bucket, err := p.Bucket()
 	if err != nil {
 		return err
@@ -134,10 +228,11 @@ func (p *Problem) StorageLoad(testGroups map[string]*TestGroup, fs billy.Filesys
 	ctx := context.Background()
 
 	copyToFS := func(pa string) error {
-		obj, err := storage.Client.GetObject(ctx, bucket, pa, minio.GetObjectOptions{})
+		obj, err := getTestObject(ctx, bucket, rev, pa)
 		if err != nil {
 			return err
 		}
+		defer obj.Close()
 
 		file, err := fs.Create(pa)
 		if err != nil {
@@ -0,0 +1,63 @@
+package problem
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rindag/service/storage"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// useFakeStorage replaces the storage client by a client of the handler during the test.
+func useFakeStorage(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := minio.New(strings.TrimPrefix(server.URL, "http://"), &minio.Options{
+		Creds:  credentials.NewStaticV4("minio", "minio123", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldClient := storage.Client
+	storage.Client = client
+	t.Cleanup(func() { storage.Client = oldClient })
+}
+
+// TestStorageHas tests that the tests of a build are stored only if all the tests are stored.
+func TestStorageHas(t *testing.T) {
+	p := NewProblem(uuid.New())
+	rev := plumbing.NewHash("0123456789abcdef0123456789abcdef01234567")
+	stored := map[string]bool{}
+	useFakeStorage(t, func(w http.ResponseWriter, r *http.Request) {
+		// The bucket of the problem exists.
+		if r.URL.Path == "/"+p.ID.String()+"/" {
+			return
+		}
+		if stored[r.URL.Path] {
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	testGroups := map[string]*TestGroup{
+		"main": {Tests: []TestCase{{Prefix: "main-0"}, {Prefix: "main-1"}}},
+	}
+
+	stored["/"+p.ID.String()+"/"+testObjectName(rev, "main-0.in")] = true
+	if has, err := p.StorageHas(rev, testGroups); err != nil || has {
+		t.Errorf("tests should not be stored if a later test is missing, got %v, %v", has, err)
+	}
+
+	stored["/"+p.ID.String()+"/"+testObjectName(rev, "main-1.in")] = true
+	if has, err := p.StorageHas(rev, testGroups); err != nil || !has {
+		t.Errorf("tests should be stored, got %v, %v", has, err)
+	}
+}
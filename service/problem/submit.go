@@ -0,0 +1,473 @@
+package problem
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"rindag/service/judge"
+
+	"github.com/criyle/go-judge/pb"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+// MaxOutputsSize is the maximum total size of the output files of an output-only submission.
+const MaxOutputsSize = 256 * 1024 * 1024
+
+// SubmitResult is the result of judging a submission.
+type SubmitResult struct {
+	// OK is true if the submission is judged, no matter whether it is accepted.
+	OK bool `json:"ok"`
+
+	Err string `json:"error,omitempty"`
+
+	// CompileResult is the compile result of the submission.
+	//
+	// It is nil for output-only problems.
+	CompileResult *RunResult `json:"compile_result,omitempty"`
+
+	// JudgeResults is a map of test case id and the judge result.
+	JudgeResults map[string]*JudgeResult `json:"judge_results,omitempty"`
+
+	// GroupScores is a map of test group name and its score.
+	GroupScores map[string]float64 `json:"group_scores,omitempty"`
+
+	// Score is the total score of the submission.
+	Score float64 `json:"score"`
+}
+
+// submitOutput is the output of a submission on a test case.
+type submitOutput struct {
+	TestCase string
+	Result   *RunResult
+
+	// OufID is the ID of the output file cached in the judge.
+	OufID string
+
+	// Ouf is the output content if it is not cached in the judge.
+	Ouf []byte
+}
+
+// oufFile returns the output file to be checked,
+// which is cached in the judge if oufID is not empty.
+func oufFile(oufID string, ouf []byte) *pb.Request_File {
+	if oufID != "" {
+		return &pb.Request_File{File: &pb.Request_File_Cached{
+			Cached: &pb.Request_CachedFile{FileID: oufID},
+		}}
+	}
+	return &pb.Request_File{File: &pb.Request_File_Memory{
+		Memory: &pb.Request_MemoryFile{Content: ouf},
+	}}
+}
+
+// ReadOutputsZip reads the output files of an output-only submission from a zip file.
+//
+// The output file of a test case is "<prefix>.out" in any directory of the zip file.
+func ReadOutputsZip(r io.ReaderAt, size int64) (map[string][]byte, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make(map[string][]byte)
+	total := int64(0)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || path.Ext(f.Name) != ".out" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(io.LimitReader(rc, MaxOutputsSize-total+1))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if total += int64(len(content)); total > MaxOutputsSize {
+			return nil, fmt.Errorf("outputs are larger than %d bytes", MaxOutputsSize)
+		}
+		name := path.Base(f.Name)
+		outputs[name[:len(name)-len(".out")]] = content
+	}
+	return outputs, nil
+}
+
+// getIdleJudge returns the judge to judge a submission on, it is replaced in tests.
+var getIdleJudge = judge.GetIdleJudge
+
+// submitRequest returns a new judge request of a submission to the problem.
+//
+// Submissions are judged before builds, since someone is waiting for them.
+func (p *Problem) submitRequest(ctx context.Context) *judge.Request {
+	return judge.NewRequest(ctx).WithKey(p.ID.String()).WithPriority(judge.PriorityHigh)
+}
+
+// loadTests loads the test cases from the storage, and compiles the checker.
+func (p *Problem) loadTests(
+	ctx context.Context, rev [20]byte, conf *Config, testGroups map[string]*TestGroup,
+	extraCompileTasks ...*judge.Task,
+) (*judge.Judge, billy.Filesystem, *Checker, error) {
+	fs := memfs.New()
+	if err := p.StorageLoad(rev, testGroups, fs); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load test cases: %w", err)
+	}
+
+	_, j, err := getIdleJudge()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get idle judge: %w", err)
+	}
+
+	// The built-in diff needs no compilation.
+	checkerCompileResult := &RunResult{Finished: true}
+	compileWG := &sync.WaitGroup{}
+
+	headers, err := p.GetHeaders(rev, conf)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get headers: %w", err)
+	}
+
+	checker := p.GetChecker(rev, conf).WithHeaders(headers)
+	compileTasks := extraCompileTasks
+	if checker.NeedCompile() {
+		checkerCompileTask, err := checker.CompileTask(func(r *pb.Response_Result, err error) bool {
+			checkerCompileResult = ParseRunResult(r, err)
+			compileWG.Done()
+			return true
+		})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf(
+				"failed to get compile task for checker '%s': %w", conf.Checker, err)
+		}
+		compileWG.Add(1)
+		compileTasks = append(compileTasks, checkerCompileTask)
+	}
+
+	// The extra compile tasks should be waited by their callbacks.
+	j.AddRequest(p.submitRequest(ctx).Execute(compileTasks...))
+	compileWG.Wait()
+
+	if err := checkerCompileResult.Err; err != nil || !checkerCompileResult.Finished {
+		return nil, nil, nil, fmt.Errorf("failed to compile checker: %v", err)
+	}
+
+	return j, fs, checker, nil
+}
+
+// checkOutputs checks the outputs of a submission, and calculates the scores.
+//
+// outputs should be closed after all the outputs are sent.
+func (p *Problem) checkOutputs(
+	ctx context.Context, j *judge.Judge, checker *Checker, fs billy.Filesystem,
+	testGroups map[string]*TestGroup, outputs <-chan submitOutput, result *SubmitResult,
+) *SubmitResult {
+	type checkResponse struct {
+		TestCase string
+		Result   *RunResult
+
+		// Status, Score and Message are the verdict of the checker.
+		Status  pb.Response_Result_StatusType
+		Score   int64
+		Message string
+	}
+
+	readFile := func(pa string) ([]byte, error) {
+		file, err := fs.Open(pa)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+
+	testScores := make(map[string]int64)
+	result.JudgeResults = make(map[string]*JudgeResult)
+
+	checkTasks := []*judge.Task{}
+	checkResponses := make(chan checkResponse, 16)
+	checkWG := &sync.WaitGroup{}
+
+	for resp := range outputs {
+		if err := resp.Result.Err; err != nil {
+			result.OK = false
+			result.Err = fmt.Sprintf("failed to run submission on test case '%s': %s",
+				resp.TestCase, err)
+			continue
+		}
+
+		infContent, err := readFile(resp.TestCase + ".in")
+		if err != nil {
+			result.OK = false
+			result.Err = fmt.Sprintf("failed to read test case input '%s': %s", resp.TestCase, err)
+			continue
+		}
+		ansContent, err := readFile(resp.TestCase + ".ans")
+		if err != nil {
+			result.OK = false
+			result.Err = fmt.Sprintf("failed to read test case answer '%s': %s", resp.TestCase, err)
+			continue
+		}
+
+		judgeResult := &JudgeResult{
+			Status: resp.Result.Status,
+			Time:   resp.Result.Time,
+			Memory: resp.Result.Memory,
+			Inf:    TruncateMessage(string(infContent)),
+			Ouf:    TruncateMessage(string(resp.Ouf)),
+			Ans:    TruncateMessage(string(ansContent)),
+		}
+		result.JudgeResults[resp.TestCase] = judgeResult
+
+		if resp.Result.Status != pb.Response_Result_Accepted {
+			continue
+		}
+
+		ouf := resp.Ouf
+		if resp.OufID != "" {
+			if oufContent, err := j.FileGet(ctx, resp.OufID); err == nil {
+				ouf = oufContent.Content
+				judgeResult.Ouf = TruncateMessage(string(ouf))
+			}
+		}
+
+		if !checker.NeedCompile() {
+			// The built-in diff compares the output directly, without a check task.
+			judgeResult.Status, judgeResult.Score, judgeResult.CheckerResult = DiffOutput(
+				ouf, ansContent, checker.Tolerance, TestFullScore)
+			testScores[resp.TestCase] = judgeResult.Score
+			continue
+		}
+
+		checkTask := func(resp submitOutput) *judge.Task {
+			return checker.CheckTask(
+				&pb.Request_File{File: &pb.Request_File_Memory{
+					Memory: &pb.Request_MemoryFile{Content: infContent},
+				}},
+				oufFile(resp.OufID, resp.Ouf),
+				&pb.Request_File{File: &pb.Request_File_Memory{
+					Memory: &pb.Request_MemoryFile{Content: ansContent},
+				}},
+				func(r *pb.Response_Result, err error) bool {
+					cResp := checkResponse{TestCase: resp.TestCase, Result: ParseRunResult(r, err)}
+					if err == nil {
+						cResp.Status, cResp.Score, cResp.Message = checker.ParseResult(r, TestFullScore)
+					}
+					checkResponses <- cResp
+					checkWG.Done()
+					return true
+				},
+			)
+		}(resp)
+
+		checkWG.Add(1)
+		checkTasks = append(checkTasks, checkTask)
+	}
+
+	if !result.OK {
+		return result
+	}
+
+	go func() {
+		checkWG.Wait()
+		close(checkResponses)
+	}()
+
+	j.AddRequest(p.submitRequest(ctx).Execute(checkTasks...))
+
+	for resp := range checkResponses {
+		if err := resp.Result.Err; err != nil {
+			result.OK = false
+			result.Err = fmt.Sprintf("failed to check test case '%s': %s", resp.TestCase, err)
+			continue
+		}
+		result.JudgeResults[resp.TestCase].Status = resp.Status
+		result.JudgeResults[resp.TestCase].CheckerResult = resp.Message
+		result.JudgeResults[resp.TestCase].Score = resp.Score
+		testScores[resp.TestCase] = resp.Score
+	}
+
+	if !result.OK {
+		return result
+	}
+
+	var err error
+	result.GroupScores, err = GroupScores(testGroups, testScores)
+	if err != nil {
+		result.OK = false
+		result.Err = fmt.Sprintf("failed to calculate scores: %s", err)
+		return result
+	}
+	result.Score = TotalScore(result.GroupScores)
+
+	return result
+}
+
+// Submit judges a solution on the test cases loaded from the storage,
+// which are built at rev with the config.
+//
+// The judge will be aborted when ctx is cancelled.
+func (p *Problem) Submit(
+	ctx context.Context, rev [20]byte, conf *Config, testGroups map[string]*TestGroup,
+	solution *Solution,
+) *SubmitResult {
+	var compileResult *RunResult
+	compileWG := &sync.WaitGroup{}
+	compileWG.Add(1)
+
+	compileTask, err := solution.CompileTask(func(r *pb.Response_Result, err error) bool {
+		compileResult = ParseRunResult(r, err)
+		compileWG.Done()
+		return true
+	})
+	if err != nil {
+		return &SubmitResult{OK: false, Err: fmt.Sprintf("failed to get compile task: %s", err)}
+	}
+
+	compileTasks := []*judge.Task{compileTask}
+
+	var manager *Manager
+	var managerCompileResult *RunResult
+	if conf.Type == ProblemTypeTwoRun {
+		headers, err := p.GetHeaders(rev, conf)
+		if err != nil {
+			return &SubmitResult{OK: false, Err: fmt.Sprintf("failed to get headers: %s", err)}
+		}
+		manager = NewManagerFromProblem(p, rev, conf.Manager).WithHeaders(headers)
+		managerCompileTask, err := manager.CompileTask(func(r *pb.Response_Result, err error) bool {
+			managerCompileResult = ParseRunResult(r, err)
+			compileWG.Done()
+			return true
+		})
+		if err != nil {
+			return &SubmitResult{
+				OK:  false,
+				Err: fmt.Sprintf("failed to get compile task for manager '%s': %s", conf.Manager, err),
+			}
+		}
+		compileWG.Add(1)
+		compileTasks = append(compileTasks, managerCompileTask)
+	}
+
+	j, fs, checker, err := p.loadTests(ctx, rev, conf, testGroups, compileTasks...)
+	if err != nil {
+		return &SubmitResult{OK: false, Err: err.Error()}
+	}
+	compileWG.Wait()
+
+	if manager != nil {
+		if err := managerCompileResult.Err; err != nil || !managerCompileResult.Finished {
+			return &SubmitResult{OK: false, Err: fmt.Sprintf("failed to compile manager: %v", err)}
+		}
+	}
+
+	result := &SubmitResult{OK: true, CompileResult: compileResult}
+
+	if err := compileResult.Err; err != nil {
+		result.OK = false
+		result.Err = fmt.Sprintf("failed to compile submission: %s", err)
+		return result
+	}
+
+	outputs := make(chan submitOutput, 16)
+
+	// Run the solution on all test cases, if it is compiled.
+	if !compileResult.Finished {
+		close(outputs)
+		return p.checkOutputs(ctx, j, checker, fs, testGroups, outputs, result)
+	}
+
+	phases := runPhases{}
+	runWG := &sync.WaitGroup{}
+
+	for _, group := range testGroups {
+		for _, test := range group.Tests {
+			file, err := fs.Open(test.Prefix + ".in")
+			if err != nil {
+				return &SubmitResult{
+					OK:  false,
+					Err: fmt.Sprintf("failed to open test case input '%s': %s", test.Prefix, err),
+				}
+			}
+			infContent, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return &SubmitResult{
+					OK:  false,
+					Err: fmt.Sprintf("failed to read test case input '%s': %s", test.Prefix, err),
+				}
+			}
+
+			tasks := func(test TestCase) []*judge.Task {
+				return solution.RunTasks(
+					conf,
+					manager,
+					group.TimeLimit,
+					group.MemoryLimit,
+					&pb.Request_File{File: &pb.Request_File_Memory{
+						Memory: &pb.Request_MemoryFile{Content: infContent},
+					}},
+					func(r *pb.Response_Result, err error) bool {
+						outputs <- submitOutput{
+							TestCase: test.Prefix,
+							Result:   ParseRunResult(r, err),
+							OufID:    r.GetFileIDs()["stdout"],
+						}
+						runWG.Done()
+						return true
+					})
+			}(test)
+
+			runWG.Add(1)
+			phases.add(tasks...)
+		}
+	}
+
+	go func() {
+		runWG.Wait()
+		close(outputs)
+	}()
+
+	j.AddRequest(phases.then(p.submitRequest(ctx)))
+
+	return p.checkOutputs(ctx, j, checker, fs, testGroups, outputs, result)
+}
+
+// SubmitOutputs judges the output files of an output-only problem by the test case prefixes,
+// on the test cases loaded from the storage, which are built at rev with the config.
+//
+// A missing output file is judged as FileError.
+// The judge will be aborted when ctx is cancelled.
+func (p *Problem) SubmitOutputs(
+	ctx context.Context, rev [20]byte, conf *Config, testGroups map[string]*TestGroup,
+	outs map[string][]byte,
+) *SubmitResult {
+	j, fs, checker, err := p.loadTests(ctx, rev, conf, testGroups)
+	if err != nil {
+		return &SubmitResult{OK: false, Err: err.Error()}
+	}
+
+	outputs := make(chan submitOutput, 16)
+	go func() {
+		defer close(outputs)
+		for _, group := range testGroups {
+			for _, test := range group.Tests {
+				out, ok := outs[test.Prefix]
+				status := pb.Response_Result_Accepted
+				if !ok {
+					status = pb.Response_Result_FileError
+				}
+				outputs <- submitOutput{
+					TestCase: test.Prefix,
+					Result:   &RunResult{Finished: ok, Status: status},
+					Ouf:      out,
+				}
+			}
+		}
+	}()
+
+	return p.checkOutputs(ctx, j, checker, fs, testGroups, outputs, &SubmitResult{OK: true})
+}
@@ -0,0 +1,119 @@
+package problem
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"rindag/service/etc"
+	"rindag/service/git"
+	"rindag/service/judge"
+
+	"github.com/criyle/go-judge/pb"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestReadOutputsZip tests that the output files in a zip file are read by test case prefixes.
+func TestReadOutputsZip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range map[string]string{
+		"main-0.out":         "1\n",
+		"outputs/main-1.out": "2\n",
+		"README.txt":         "ignored",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	outputs, err := ReadOutputsZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 2 || string(outputs["main-0"]) != "1\n" || string(outputs["main-1"]) != "2\n" {
+		t.Errorf("outputs should be main-0 and main-1, but %v", outputs)
+	}
+}
+
+// managerFailingExecutor is an executor which fails the compile of managers, and blocks the other
+// commands until they are aborted.
+type managerFailingExecutor struct {
+	pb.UnimplementedExecutorServer
+}
+
+func (managerFailingExecutor) Exec(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+	if _, ok := req.Cmd[0].CopyIn["manager.cpp"]; ok {
+		return nil, status.Error(codes.InvalidArgument, "bad request")
+	}
+	<-ctx.Done()
+	return nil, status.FromContextError(ctx.Err()).Err()
+}
+
+// TestSubmitManagerCompileFailed tests that a submission fails without blocking,
+// when the compile of the manager fails to execute and aborts the compile of the submission.
+func TestSubmitManagerCompileFailed(t *testing.T) {
+	oldDir, oldWorktree := etc.Config.Git.RepoDir, etc.Config.Problem.InitialWorktree
+	etc.Config.Git.RepoDir = t.TempDir()
+	etc.Config.Problem.InitialWorktree = map[string]string{"manager.cpp": ""}
+	defer func() {
+		etc.Config.Git.RepoDir, etc.Config.Problem.InitialWorktree = oldDir, oldWorktree
+		getIdleJudge = judge.GetIdleJudge
+	}()
+
+	// All the buckets exist in the storage, and there is no test case to load.
+	useFakeStorage(t, func(http.ResponseWriter, *http.Request) {})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterExecutorServer(server, managerFailingExecutor{})
+	go server.Serve(lis)
+	defer server.Stop()
+	id := "manager-compile-failed-" + uuid.NewString()
+	if err := judge.AddAndStart(id, lis.Addr().String(), "", 2); err != nil {
+		t.Fatal(err)
+	}
+	getIdleJudge = func() (string, *judge.Judge, error) {
+		j, err := judge.GetJudge(id)
+		return id, j, err
+	}
+
+	p := NewProblem(uuid.New())
+	rev, err := p.ResolveRef(git.MainBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &Config{Type: ProblemTypeTwoRun, CheckerProtocol: CheckerDiff, Manager: "manager.cpp"}
+
+	results := make(chan *SubmitResult)
+	go func() {
+		results <- p.Submit(context.Background(), rev, conf, map[string]*TestGroup{},
+			NewSolutionFromBytes([]byte{}))
+	}()
+	select {
+	case result := <-results:
+		if result.OK || !strings.Contains(result.Err, "failed to compile manager") {
+			t.Errorf("submission should fail to compile manager, but %+v", result)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("submission is blocked")
+	}
+}
@@ -6,10 +6,17 @@ type TestGroup struct {
 
 	// FullScore is the score of this group.
 	//
-	// patient's score = FullScore *
+	// With the default scoring mode, patient's score = FullScore *
 	//   min(min_{s \in dependencies} Score(s) / FullScore(s), min_{t \in tests} score(t) / 100)
 	FullScore int32 `json:"full_score"`
 
+	// Scoring is the mode to aggregate the scores of the tests, see ScoringMode.
+	//
+	// - "min" (default): the formula above.
+	// - "sum": the average of score(t) / 100 instead of the minimum.
+	// - "icpc": FullScore if all the tests and dependencies are fully passed, otherwise 0.
+	Scoring ScoringMode `json:"scoring,omitempty"`
+
 	// TimeLimit is the time limit in nanoseconds of this group.
 	TimeLimit uint64 `json:"time_limit"`
 
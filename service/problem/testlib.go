@@ -1,10 +1,19 @@
 package problem
 
 import (
-	_ "embed"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 
+	"rindag/service/etc"
+
 	"github.com/criyle/go-judge/pb"
 )
 
@@ -13,6 +22,71 @@ const MaxTestlibMessageLen = 1024
 //go:embed third_party/testlib/testlib.h
 var TestlibSource []byte
 
+// bundledTestlibs are the pinned testlib versions, see "testlib/README.md".
+//
+//go:embed testlib
+var bundledTestlibs embed.FS
+
+// ErrUnknownTestlib is returned when a testlib version is not bundled.
+var ErrUnknownTestlib = errors.New("unknown testlib version")
+
+// defaultHeaders returns the headers of testlib programs by default.
+func defaultHeaders() map[string][]byte {
+	return map[string][]byte{"testlib.h": TestlibSource}
+}
+
+// BundledTestlib returns the source of the bundled testlib of the version, like "0.9.12".
+//
+// The versions in the testlib directory of config override the embedded versions,
+// the version "<v>" is "<dir>/<v>/testlib.h".
+func BundledTestlib(version string) ([]byte, error) {
+	if version == "" || version == "." || version == ".." || version != path.Base(version) {
+		return nil, ErrUnknownTestlib
+	}
+
+	if dir := etc.Config.Testlib.Dir; dir != "" {
+		source, err := os.ReadFile(filepath.Join(dir, version, "testlib.h"))
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return source, err
+		}
+	}
+
+	source, err := bundledTestlibs.ReadFile(
+		path.Join("testlib", version, "testlib.h"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrUnknownTestlib
+	}
+	return source, err
+}
+
+// GetHeaders returns the headers to compile the programs of the problem with,
+// which are the "testlib.h" in config and the extra includes, by their file names.
+//
+// The testlib in config is a path of the problem at rev, or a bundled version.
+// If it is empty, TestlibSource is used.
+func (p *Problem) GetHeaders(rev [20]byte, conf *Config) (map[string][]byte, error) {
+	headers, err := p.readFiles(rev, conf.Includes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read includes: %w", err)
+	}
+
+	headers["testlib.h"] = TestlibSource
+	if conf.Testlib == "" {
+		return headers, nil
+	}
+	if r, err := p.File(rev, conf.Testlib); err == nil {
+		defer r.Close()
+		if headers["testlib.h"], err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+		return headers, nil
+	}
+	if headers["testlib.h"], err = BundledTestlib(conf.Testlib); err != nil {
+		return nil, fmt.Errorf("failed to get testlib '%s': %w", conf.Testlib, err)
+	}
+	return headers, nil
+}
+
 func ParseTestlibOutput(output string, fullScore int64) (
 	pb.Response_Result_StatusType, int64, string,
 ) {
@@ -20,11 +20,14 @@ type Validator struct {
 
 	// GetSource is a function returns the source code ReadCloser of the checker.
 	GetSource func() (io.ReadCloser, error)
+
+	// Headers are the headers copied in when compiling the validator, by their file names.
+	Headers map[string][]byte
 }
 
 // NewValidator creates a validator.
 func NewValidator(getSource func() (io.ReadCloser, error)) *Validator {
-	return &Validator{binaryID: new(string), GetSource: getSource}
+	return &Validator{binaryID: new(string), GetSource: getSource, Headers: defaultHeaders()}
 }
 
 // NewValidatorFromProblem creates a validator from a problem.
@@ -43,6 +46,12 @@ func NewValidatorFromReadCloser(r io.ReadCloser) *Validator {
 	return NewValidator(func() (io.ReadCloser, error) { return r, nil })
 }
 
+// WithHeaders sets the headers of the validator, see Problem.GetHeaders.
+func (v *Validator) WithHeaders(headers map[string][]byte) *Validator {
+	v.Headers = headers
+	return v
+}
+
 // CompileTask returns the compile task of the validator.
 func (v *Validator) CompileTask(cb judge.CallbackFunction) (*judge.Task, error) {
 	conf := etc.Config
@@ -56,6 +65,7 @@ func (v *Validator) CompileTask(cb judge.CallbackFunction) (*judge.Task, error)
 		return nil, err
 	}
 	return judge.DefaultTask().
+		WithKind(judge.TaskKindCompile).
 		WithCmd(conf.Compile.Cmd...).
 		WithCmd(conf.Validator.Compile.Args...).
 		WithCmd("validator.cpp", "-o", "validator").
@@ -63,7 +73,7 @@ func (v *Validator) CompileTask(cb judge.CallbackFunction) (*judge.Task, error)
 		WithMemoryLimit(conf.Compile.MemoryLimit).
 		WithStderrLimit(conf.Compile.StderrLimit).
 		WithCopyIn("validator.cpp", bytes).
-		WithCopyIn("testlib.h", TestlibSource).
+		WithCopyInFiles(v.Headers).
 		WithCopyOut("validator").
 		WithCallback(func(r *pb.Response_Result, err error) bool {
 			if finished := err == nil && r.Status == pb.Response_Result_Accepted; finished {
@@ -84,6 +94,7 @@ func (v *Validator) ValidateTask(
 ) *judge.Task {
 	conf := &etc.Config.Validator
 	return judge.DefaultTask().
+		WithKind(judge.TaskKindValidate).
 		WithCmd("validator").
 		WithCmd(args...).
 		WithTimeLimit(conf.Run.TimeLimit).
@@ -0,0 +1,14 @@
+#!/usr/bin/env bash
+
+# Fetch the testlib versions bundled in rindag from the tags of the upstream repository.
+# Add a version to the list and run this script to bundle it.
+
+set -e
+
+versions=("0.9.12" "0.9.34" "0.9.41")
+
+for version in "${versions[@]}"; do
+  mkdir -p "service/problem/testlib/$version"
+  curl -fsSL -o "service/problem/testlib/$version/testlib.h" \
+    "https://raw.githubusercontent.com/MikeMirzayanov/testlib/$version/testlib.h"
+done